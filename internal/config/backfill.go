@@ -0,0 +1,112 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// BackfillConfig defines how the table is walked in backfill mode: in chunks ordered by the backfill cursor, from its
+// own checkpoint instead of the cursor of the job, so it can run along with the live worker.
+type BackfillConfig struct {
+	// SelectQuery reads the chunk of rows after the checkpoint, e.g. by primary key range. When empty, the select query
+	// and cursor of the job are used.
+	SelectQuery string `yaml:"selectQuery" env:"SELECT_QUERY"`
+
+	// Cursor is the cursor of the backfill select query, empty settings are inherited from the top-level backfill
+	// cursor. Lookback is not supported.
+	Cursor CursorConfig `yaml:"cursor" env-prefix:"CURSOR_"`
+
+	// BatchSize is the number of rows of each chunk, the batch size of the job by default.
+	BatchSize int `yaml:"batchSize" env:"BATCH_SIZE"`
+
+	// RowsPerSecond limits the throughput of the backfill to protect the db and redis. When 0, chunks are read without
+	// delay.
+	RowsPerSecond int `yaml:"rowsPerSecond" env:"ROWS_PER_SECOND"`
+
+	// CheckpointKey is the key of the progress of the backfill, removed once it completes. Defaults to the cursor key
+	// followed by ":backfill".
+	CheckpointKey string `yaml:"checkpointKey" env:"CHECKPOINT_KEY"`
+
+	// queryParams are the names of the parameters of the backfill select query, set when it's bound.
+	queryParams []string
+}
+
+// BackfillJob returns the config of the job used in backfill mode: the backfill query, cursor and batch size,
+// storing the progress in the checkpoint key without the marker and timestamp keys.
+func (c *JobConfig) BackfillJob() JobConfig {
+	job := *c
+	job.Name += "/backfill"
+	job.BatchSize = c.DB.Backfill.BatchSize
+	job.DB.SelectQuery = c.DB.Backfill.SelectQuery
+	job.DB.Cursor = c.DB.Backfill.Cursor
+	job.DB.queryParams = c.DB.Backfill.queryParams
+	job.Redis.CursorKey = c.DB.Backfill.CheckpointKey
+	job.Redis.MarkerKey = ""
+	job.Redis.TimestampKey = ""
+	job.liveKeys = []string{c.Redis.CursorKey, c.Redis.MarkerKey, c.Redis.TimestampKey}
+	if b := c.DB.Backfill.Cursor; b.Column == c.DB.Cursor.Column && b.Type == c.DB.Cursor.Type {
+		job.liveCursorKey = c.Redis.CursorKey
+	}
+	return job
+}
+
+// LiveCursorKey returns the cursor key of the live job for the backfill jobs reading the same cursor column and type,
+// so the checkpoint is a valid cursor of the live job. Empty otherwise.
+func (c *JobConfig) LiveCursorKey() string {
+	return c.liveCursorKey
+}
+
+// ReservedKeys returns the keys written by the worker besides the keys of the rows: the cursor, marker, timestamp,
+// backfill checkpoint and generation keys, along with the ones of the live job for the backfill jobs.
+func (c *JobConfig) ReservedKeys() []string {
+	keys := []string{c.Redis.CursorKey, c.Redis.MarkerKey, c.Redis.TimestampKey, c.DB.Backfill.CheckpointKey}
+	if generation := c.Redis.Generation; generation.Name != "" {
+		keys = append(keys, generation.PointerKey, generation.PatternsKey(), generation.RetiredKey())
+	}
+	keys = append(keys, c.liveKeys...)
+	return slices.DeleteFunc(keys, func(key string) bool { return key == "" })
+}
+
+// validateBackfill inherits the backfill settings from the job and the top-level backfill config, binding the
+// backfill select query. It's called before binding the select query of the job, to use it as the default.
+func validateBackfill(c *Config, dialect Dialect, job *JobConfig) error {
+	b := &job.DB.Backfill
+	top := &c.DB.Backfill
+	if b.SelectQuery == "" {
+		b.SelectQuery = job.DB.SelectQuery
+		b.Cursor = job.DB.Cursor
+	} else {
+		b.Cursor.Column = cmp.Or(b.Cursor.Column, top.Cursor.Column)
+		b.Cursor.Type = cmp.Or(b.Cursor.Type, top.Cursor.Type)
+		b.Cursor.Default = cmp.Or(b.Cursor.Default, top.Cursor.Default)
+		b.Cursor.TimeFormat = cmp.Or(b.Cursor.TimeFormat, top.Cursor.TimeFormat)
+	}
+	b.Cursor.Lookback = LookbackConfig{}
+	b.BatchSize = cmp.Or(b.BatchSize, top.BatchSize, job.BatchSize)
+	b.RowsPerSecond = cmp.Or(b.RowsPerSecond, top.RowsPerSecond)
+	b.CheckpointKey = cmp.Or(b.CheckpointKey, job.Redis.CursorKey+":backfill")
+
+	switch {
+	case b.BatchSize < 0:
+		return errors.New("backfill batch size should be greater than 0")
+	case b.RowsPerSecond < 0:
+		return errors.New("backfill rows per second should not be negative")
+	case b.CheckpointKey == job.Redis.CursorKey:
+		return errors.New("backfill checkpoint key should be different from the cursor key")
+	}
+
+	if _, err := b.Cursor.Info(); err != nil {
+		return fmt.Errorf("invalid backfill cursor: %w", err)
+	}
+
+	backfill := JobConfig{DB: JobDBConfig{SelectQuery: b.SelectQuery, Cursor: b.Cursor, Params: job.DB.Params}}
+	backfill.BatchSize = b.BatchSize
+	if err := backfill.BindSelectQuery(dialect); err != nil {
+		return fmt.Errorf("invalid backfill: %w", err)
+	}
+	b.SelectQuery = backfill.DB.SelectQuery
+	b.queryParams = backfill.DB.queryParams
+	return nil
+}
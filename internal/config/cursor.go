@@ -0,0 +1,538 @@
+package config
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CursorTuple is the value of a compound cursor, one element per cursor column. It's stored in redis as a json array
+// and compared lexicographically.
+type CursorTuple []any
+
+// Cursor types.
+const (
+	CursorTypeInt64       = "int64"
+	CursorTypeInt32       = "int32"
+	CursorTypeInt         = "int"
+	CursorTypeUint64      = "uint64"
+	CursorTypeString      = "string"
+	CursorTypeUUID        = "uuid"
+	CursorTypeTimestamp   = "timestamp"
+	CursorTypeTimestampTZ = "timestamptz"
+	CursorTypeNumeric     = "numeric"
+	CursorTypeDecimal     = "decimal"
+	// CursorTypeSnapshot is the postgres transaction id of the rows, read up to the xmin of the current snapshot.
+	CursorTypeSnapshot = "snapshot"
+)
+
+// Time formats of the timestamp cursors stored as the time since epoch.
+const (
+	TimeFormatUnix      = "unix"
+	TimeFormatUnixMilli = "unixmilli"
+	TimeFormatUnixMicro = "unixmicro"
+)
+
+// SnapshotCursor is the value of a snapshot cursor bound to the select query, along with the xmin of the current
+// snapshot: the transactions with lower ids are no longer in progress.
+type SnapshotCursor struct {
+	Cursor any
+	Xmin   int64
+}
+
+// CursorInfo contains the functions to read, compare and convert the cursor values.
+type CursorInfo struct {
+	Columns     []string
+	Types       []string
+	Default     any
+	CompareFunc ComparatorFunc
+	ConvertFunc ConvertFunc
+
+	columnTypes []*cursorType
+}
+
+// cursorType contains the functions to handle the values of a cursor column: convert parses the value stored in
+// redis, format returns the value to store, decode converts the value scanned from the db and compare orders them.
+type cursorType struct {
+	convert ConvertFunc
+	format  func(value any) string
+	decode  DecodeFunc
+	compare ComparatorFunc
+}
+
+func (c *CursorConfig) Info() (*CursorInfo, error) {
+	columns := splitList(c.Column)
+	if len(columns) == 0 {
+		return nil, errors.New("cursor column should be defined")
+	}
+	types, err := expandList("types", splitList(c.Type), len(columns))
+	if err != nil {
+		return nil, err
+	}
+	// The defaults are not trimmed, as spaces can be part of the values
+	defaults := []string{c.Default}
+	if len(columns) > 1 {
+		if defaults, err = expandList("defaults", strings.Split(c.Default, ","), len(columns)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(columns) > 1 && slices.Contains(types, CursorTypeSnapshot) {
+		return nil, errors.New("snapshot cursor is not supported with compound cursors")
+	}
+
+	columnTypes := make([]*cursorType, len(columns))
+	defaultValues := make(CursorTuple, len(columns))
+	for i := range columns {
+		columnTypes[i], err = newCursorType(types[i], c.TimeFormat)
+		if err != nil {
+			return nil, err
+		}
+		defaultValues[i], err = columnTypes[i].convert(defaults[i])
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert default value: %w", err)
+		}
+	}
+
+	if len(columns) == 1 {
+		return &CursorInfo{
+			Columns:     columns,
+			Types:       types,
+			Default:     defaultValues[0],
+			CompareFunc: columnTypes[0].compare,
+			ConvertFunc: columnTypes[0].convert,
+			columnTypes: columnTypes,
+		}, nil
+	}
+
+	return &CursorInfo{
+		Columns:     columns,
+		Types:       types,
+		Default:     defaultValues,
+		CompareFunc: tupleCompareFunc(columnTypes),
+		ConvertFunc: tupleConvertFunc(columnTypes),
+		columnTypes: columnTypes,
+	}, nil
+}
+
+// DefaultGapTimeout is the time after which a gap in the ids of the lookback range is considered a rolled back
+// transaction.
+const DefaultGapTimeout = time.Minute
+
+// Validate checks that the lookback matches the cursor type and is lower than the batch size, so each batch reads
+// new rows besides the ones in the range.
+func (c *LookbackConfig) Validate(info *CursorInfo, batchSize int) error {
+	switch {
+	case c.IDs == 0 && c.Window == 0:
+		return nil
+	case c.IDs < 0 || c.Window < 0 || c.GapTimeout < 0:
+		return errors.New("lookback should be greater than 0")
+	case c.IDs > 0 && c.Window > 0:
+		return errors.New("lookback ids and window can't be used together")
+	case len(info.Columns) > 1:
+		return errors.New("lookback is not supported with compound cursors")
+	case c.IDs > 0 && !slices.Contains([]string{CursorTypeInt64, CursorTypeInt32, CursorTypeInt}, info.Types[0]):
+		return fmt.Errorf("lookback ids is not supported with %s cursors", info.Types[0])
+	case c.IDs >= int64(batchSize):
+		return errors.New("lookback ids should be lower than the batch size")
+	case c.Window > 0 && info.Types[0] != CursorTypeTimestamp && info.Types[0] != CursorTypeTimestampTZ:
+		return fmt.Errorf("lookback window is not supported with %s cursors", info.Types[0])
+	}
+	return nil
+}
+
+// Snapshot returns true when the cursor reads the rows up to the xmin of the current snapshot.
+func (c *CursorInfo) Snapshot() bool {
+	return c.Types[0] == CursorTypeSnapshot
+}
+
+// Value returns the cursor value of the row, converted to the cursor type.
+func (c *CursorInfo) Value(row map[string]any) (any, error) {
+	values := make(CursorTuple, len(c.Columns))
+	for i, column := range c.Columns {
+		value := row[column]
+		if value == nil {
+			return nil, fmt.Errorf("cursor column '%s' is nil or does not exists", column)
+		}
+
+		decoded, err := c.columnTypes[i].decode(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for cursor column '%s': %w", column, err)
+		}
+		values[i] = decoded
+	}
+
+	if len(values) == 1 {
+		return values[0], nil
+	}
+	return values, nil
+}
+
+// Format returns the cursor value as stored in redis, compound cursors are stored as a json array of strings.
+func (c *CursorInfo) Format(value any) string {
+	tuple, ok := value.(CursorTuple)
+	if !ok {
+		return c.columnTypes[0].format(value)
+	}
+
+	values := make([]string, len(tuple))
+	for i, v := range tuple {
+		values[i] = c.columnTypes[i].format(v)
+	}
+	result, _ := json.Marshal(values)
+	return string(result)
+}
+
+func tupleConvertFunc(columnTypes []*cursorType) ConvertFunc {
+	return func(value string) (any, error) {
+		var values []string
+		if err := json.Unmarshal([]byte(value), &values); err != nil {
+			return nil, fmt.Errorf("compound cursor should be a json array: %w", err)
+		}
+		if len(values) != len(columnTypes) {
+			return nil, fmt.Errorf("compound cursor should contain %d values, got %d", len(columnTypes), len(values))
+		}
+
+		result := make(CursorTuple, len(values))
+		for i, v := range values {
+			converted, err := columnTypes[i].convert(v)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	}
+}
+
+func tupleCompareFunc(columnTypes []*cursorType) ComparatorFunc {
+	return func(a, b any) (int, error) {
+		aTuple, ok := a.(CursorTuple)
+		if !ok || len(aTuple) != len(columnTypes) {
+			return 0, fmt.Errorf("unable to convert a to %T", aTuple)
+		}
+		bTuple, ok := b.(CursorTuple)
+		if !ok || len(bTuple) != len(columnTypes) {
+			return 0, fmt.Errorf("unable to convert b to %T", bTuple)
+		}
+
+		for i, t := range columnTypes {
+			result, err := t.compare(aTuple[i], bTuple[i])
+			if err != nil || result != 0 {
+				return result, err
+			}
+		}
+		return 0, nil
+	}
+}
+
+// splitList returns the trimmed comma-separated values.
+func splitList(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// expandList repeats a single value for each cursor column.
+func expandList(name string, values []string, length int) ([]string, error) {
+	if len(values) == 1 && length > 1 {
+		values = slices.Repeat(values, length)
+	}
+	if len(values) != length {
+		return nil, fmt.Errorf("cursor %s should match the %d cursor columns", name, length)
+	}
+	return values, nil
+}
+
+func newCursorType(name string, timeFormat string) (*cursorType, error) {
+	switch name {
+	case CursorTypeInt64, CursorTypeInt32, CursorTypeSnapshot:
+		bitSize := 64
+		if name == CursorTypeInt32 {
+			bitSize = 32
+		}
+		return &cursorType{
+			convert: func(value string) (any, error) {
+				return strconv.ParseInt(value, 10, bitSize)
+			},
+			format:  formatCursorValue,
+			decode:  decodeIntCursor,
+			compare: getCompareFunc[int64](),
+		}, nil
+	case CursorTypeInt:
+		return &cursorType{
+			convert: func(value string) (any, error) {
+				return strconv.Atoi(value)
+			},
+			format: formatCursorValue,
+			decode: func(value any) (any, error) {
+				v, err := decodeIntCursor(value)
+				if err != nil {
+					return nil, err
+				}
+				return int(v.(int64)), nil
+			},
+			compare: getCompareFunc[int](),
+		}, nil
+	case CursorTypeUint64:
+		return &cursorType{
+			convert: func(value string) (any, error) {
+				return strconv.ParseUint(value, 10, 64)
+			},
+			format:  formatCursorValue,
+			decode:  decodeUintCursor,
+			compare: getCompareFunc[uint64](),
+		}, nil
+	case CursorTypeString, CursorTypeUUID:
+		return &cursorType{
+			convert: func(value string) (any, error) {
+				return value, nil
+			},
+			format:  formatCursorValue,
+			decode:  decodeText,
+			compare: getCompareFunc[string](),
+		}, nil
+	case CursorTypeTimestamp, CursorTypeTimestampTZ:
+		return timeCursorType(cmp.Or(timeFormat, time.RFC3339Nano))
+	case CursorTypeNumeric, CursorTypeDecimal:
+		return &cursorType{
+			convert: func(value string) (any, error) {
+				return decodeDecimalCursor(value)
+			},
+			format:  formatCursorValue,
+			decode:  decodeDecimalCursor,
+			compare: compareDecimal,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type: %s", name)
+}
+
+// timeCursorType returns the cursor type of timestamps, stored in redis with the time format. The values are
+// normalized to UTC, as the drivers bind times with an offset that timestamp columns without time zone ignore.
+// The values are stored with the nanoseconds, otherwise the rows sharing the truncated value would be read again on
+// each poll and the cursor would not advance past them.
+func timeCursorType(timeFormat string) (*cursorType, error) {
+	unit, isUnix := unixTimeUnits[timeFormat]
+	if !isUnix {
+		sample := time.Date(2001, 2, 3, 4, 5, 6, 123456789, time.UTC)
+		if parsed, err := time.Parse(timeFormat, sample.Format(timeFormat)); err != nil || !parsed.Equal(sample) {
+			return nil, fmt.Errorf("time format '%s' should keep the nanoseconds of the cursor values", timeFormat)
+		}
+	}
+
+	return &cursorType{
+		convert: func(value string) (any, error) {
+			var (
+				t   time.Time
+				err error
+			)
+			if isUnix {
+				t, err = parseUnixTime(value, unit)
+			} else {
+				t, err = time.Parse(timeFormat, value)
+			}
+			if err != nil {
+				// Fallback to the usual layouts, e.g. for the default value
+				return decodeTimeCursor(value)
+			}
+			return t.UTC(), nil
+		},
+		format: func(value any) string {
+			t, ok := value.(time.Time)
+			if !ok {
+				return formatCursorValue(value)
+			}
+			if isUnix {
+				return formatUnixTime(t, unit)
+			}
+			return t.Format(timeFormat)
+		},
+		decode: decodeTimeCursor,
+		compare: func(a, b any) (int, error) {
+			aTime, ok := a.(time.Time)
+			if !ok {
+				return 0, fmt.Errorf("unable to convert a to %T", aTime)
+			}
+			bTime, ok := b.(time.Time)
+			if !ok {
+				return 0, fmt.Errorf("unable to convert b to %T", bTime)
+			}
+			return aTime.Compare(bTime), nil
+		},
+	}, nil
+}
+
+// unixTimeUnits contains the units of the time formats stored as the time since epoch.
+var unixTimeUnits = map[string]time.Duration{
+	TimeFormatUnix:      time.Second,
+	TimeFormatUnixMilli: time.Millisecond,
+	TimeFormatUnixMicro: time.Microsecond,
+}
+
+// formatUnixTime returns the time since epoch in the unit, followed by the remaining nanoseconds as a fraction of the
+// unit when not zero, e.g. "1727785800.0000005".
+func formatUnixTime(t time.Time, unit time.Duration) string {
+	nanos := int64(t.Nanosecond())
+	result := strconv.FormatInt(t.Unix()*int64(time.Second/unit)+nanos/int64(unit), 10)
+	if remainder := nanos % int64(unit); remainder > 0 {
+		digits := len(strconv.FormatInt(int64(unit), 10)) - 1
+		result += "." + strings.TrimRight(fmt.Sprintf("%0*d", digits, remainder), "0")
+	}
+	return result
+}
+
+// parseUnixTime parses the values returned by formatUnixTime.
+func parseUnixTime(value string, unit time.Duration) (time.Time, error) {
+	whole, fraction, _ := strings.Cut(value, ".")
+	n, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nanos uint64
+	if digits := len(strconv.FormatInt(int64(unit), 10)) - 1; fraction != "" {
+		if len(fraction) > digits {
+			return time.Time{}, fmt.Errorf("invalid fraction of the time unit '%s'", fraction)
+		}
+		if nanos, err = strconv.ParseUint(fraction+strings.Repeat("0", digits-len(fraction)), 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	switch unit {
+	case time.Millisecond:
+		return time.UnixMilli(n).Add(time.Duration(nanos)), nil
+	case time.Microsecond:
+		return time.UnixMicro(n).Add(time.Duration(nanos)), nil
+	}
+	return time.Unix(n, int64(nanos)), nil
+}
+
+func formatCursorValue(value any) string {
+	return fmt.Sprint(value)
+}
+
+// decodeIntCursor converts the integer values returned by the drivers into int64.
+func decodeIntCursor(value any) (any, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case int32:
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	case json.Number:
+		return v.Int64()
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return nil, fmt.Errorf("unsupported integer type %T", value)
+}
+
+// decodeUintCursor converts the unsigned values returned by the drivers into uint64, e.g. the text of the unsigned
+// bigint columns of mysql.
+func decodeUintCursor(value any) (any, error) {
+	switch v := value.(type) {
+	case uint64:
+		return v, nil
+	case int64:
+		if v < 0 {
+			return nil, fmt.Errorf("negative value %d", v)
+		}
+		return uint64(v), nil
+	case json.Number:
+		return strconv.ParseUint(string(v), 10, 64)
+	case []byte:
+		return strconv.ParseUint(string(v), 10, 64)
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	}
+	return nil, fmt.Errorf("unsupported unsigned integer type %T", value)
+}
+
+func decodeTimeCursor(value any) (any, error) {
+	decoded, err := decodeTime(value)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := decoded.(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("unsupported time type %T", value)
+	}
+	return t.UTC(), nil
+}
+
+// decodeDecimalCursor converts the exact numeric values into json.Number, which are compared without losing
+// precision.
+func decodeDecimalCursor(value any) (any, error) {
+	var text string
+	switch v := value.(type) {
+	case json.Number:
+		text = string(v)
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case uint64:
+		text = strconv.FormatUint(v, 10)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return nil, fmt.Errorf("unsupported decimal type %T", value)
+	}
+
+	if _, ok := new(big.Rat).SetString(text); !ok {
+		return nil, fmt.Errorf("invalid decimal value '%s'", text)
+	}
+	return json.Number(text), nil
+}
+
+func compareDecimal(a, b any) (int, error) {
+	aNumber, ok := a.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("unable to convert a to %T", aNumber)
+	}
+	bNumber, ok := b.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("unable to convert b to %T", bNumber)
+	}
+
+	aRat, ok := new(big.Rat).SetString(string(aNumber))
+	if !ok {
+		return 0, fmt.Errorf("invalid decimal value '%s'", aNumber)
+	}
+	bRat, ok := new(big.Rat).SetString(string(bNumber))
+	if !ok {
+		return 0, fmt.Errorf("invalid decimal value '%s'", bNumber)
+	}
+	return aRat.Cmp(bRat), nil
+}
+
+func getCompareFunc[T cmp.Ordered]() ComparatorFunc {
+	return func(a, b any) (int, error) {
+		aComparable, ok := a.(T)
+		if !ok {
+			return 0, fmt.Errorf("unable to convert a to %T", aComparable)
+		}
+		bComparable, ok := b.(T)
+		if !ok {
+			return 0, fmt.Errorf("unable to convert b to %T", bComparable)
+		}
+		return cmp.Compare(aComparable, bComparable), nil
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+var tableNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// Validate checks that the store types are supported and the sql table is a valid identifier.
+func (c *CursorStoreConfig) Validate() error {
+	if len(c.Types) == 0 {
+		return errors.New("at least one cursor store type should be defined")
+	}
+
+	for i, t := range c.Types {
+		switch t {
+		case CursorStoreRedis, CursorStoreFile:
+		case CursorStoreSQL:
+			if !tableNameRegex.MatchString(c.Table) {
+				return fmt.Errorf("invalid cursor store table name '%s'", c.Table)
+			}
+		default:
+			return fmt.Errorf("unsupported cursor store type: %s", t)
+		}
+		if slices.Contains(c.Types[:i], t) {
+			return fmt.Errorf("duplicated cursor store type: %s", t)
+		}
+	}
+
+	if slices.Contains(c.Types, CursorStoreFile) && c.File == "" {
+		return errors.New("cursor store file should be defined")
+	}
+	return nil
+}
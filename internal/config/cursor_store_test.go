@@ -0,0 +1,27 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CursorStoreConfig", func() {
+	Describe("Validate()", func() {
+		It("should accept the supported stores", func() {
+			c := CursorStoreConfig{Types: []string{"redis", "sql", "file"}, Table: "worker.cursors", File: "cursors.json"}
+			Expect(c.Validate()).To(Succeed())
+		})
+
+		It("should fail when the stores are not valid", func() {
+			for _, c := range []CursorStoreConfig{
+				{},
+				{Types: []string{"etcd"}},
+				{Types: []string{"redis", "redis"}},
+				{Types: []string{"sql"}, Table: "cursors; DROP TABLE users"},
+				{Types: []string{"file"}},
+			} {
+				Expect(c.Validate()).NotTo(Succeed(), "store %v", c)
+			}
+		})
+	})
+})
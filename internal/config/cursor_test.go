@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CursorConfig", func() {
+	Describe("Info()", func() {
+		It("should return the single column cursor", func() {
+			c := CursorConfig{Column: "id", Type: "int64", Default: "-1"}
+			info, err := c.Info()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Columns).To(Equal([]string{"id"}))
+			Expect(info.Default).To(Equal(int64(-1)))
+			Expect(info.Value(map[string]any{"id": int64(3)})).To(Equal(int64(3)))
+			Expect(info.Format(int64(3))).To(Equal("3"))
+		})
+
+		It("should return the compound cursor", func() {
+			c := CursorConfig{Column: "name, id", Type: "string,int64", Default: ",-1"}
+			info, err := c.Info()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Columns).To(Equal([]string{"name", "id"}))
+			Expect(info.Default).To(Equal(CursorTuple{"", int64(-1)}))
+
+			value, err := info.Value(map[string]any{"id": int64(3), "name": "b"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(CursorTuple{"b", int64(3)}))
+			Expect(info.Format(value)).To(Equal(`["b","3"]`))
+			Expect(info.ConvertFunc(`["b","3"]`)).To(Equal(value))
+
+			_, err = info.Value(map[string]any{"id": int64(3)})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should compare the compound cursor lexicographically", func() {
+			c := CursorConfig{Column: "revision,id", Type: "int64", Default: "-1"}
+			info, err := c.Info()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Default).To(Equal(CursorTuple{int64(-1), int64(-1)}))
+
+			tests := []struct {
+				a, b     CursorTuple
+				expected int
+			}{
+				{CursorTuple{int64(1), int64(5)}, CursorTuple{int64(2), int64(1)}, -1},
+				{CursorTuple{int64(2), int64(1)}, CursorTuple{int64(2), int64(5)}, -1},
+				{CursorTuple{int64(2), int64(5)}, CursorTuple{int64(2), int64(5)}, 0},
+				{CursorTuple{int64(3), int64(1)}, CursorTuple{int64(2), int64(5)}, 1},
+			}
+			for _, test := range tests {
+				Expect(info.CompareFunc(test.a, test.b)).To(Equal(test.expected), "%v and %v", test.a, test.b)
+			}
+		})
+
+		Describe("types", func() {
+			updatedAt := time.Date(2024, 10, 1, 12, 30, 0, 500, time.UTC)
+			updatedAtMicro := updatedAt.Truncate(time.Microsecond)
+
+			tests := []struct {
+				cursor   CursorConfig
+				dbValue  any
+				value    any
+				stored   string
+				previous string
+			}{
+				{CursorConfig{Type: "int"}, int64(7), 7, "7", "6"},
+				{CursorConfig{Type: "int32"}, []byte("7"), int64(7), "7", "6"},
+				{CursorConfig{Type: "uint64"}, "18446744073709551615", uint64(18446744073709551615),
+					"18446744073709551615", "18446744073709551614"},
+				{CursorConfig{Type: "uint64"}, int64(7), uint64(7), "7", "6"},
+				{CursorConfig{Type: "uuid"}, []byte("a0"), "a0", "a0", "a"},
+				{CursorConfig{Type: "numeric"}, []byte("12345678901234567890.12"), json.Number("12345678901234567890.12"),
+					"12345678901234567890.12", "12345678901234567890.11"},
+				{CursorConfig{Type: "decimal"}, json.Number("1.50"), json.Number("1.50"), "1.50", "1.4999"},
+				{CursorConfig{Type: "timestamptz"}, updatedAt, updatedAt, "2024-10-01T12:30:00.0000005Z",
+					"2024-10-01T12:30:00Z"},
+				{CursorConfig{Type: "timestamp"}, []byte("2024-10-01 14:30:00.0000005+02"), updatedAt,
+					"2024-10-01T12:30:00.0000005Z", "2024-10-01T12:30:00Z"},
+				{CursorConfig{Type: "timestamp", TimeFormat: "2006-01-02 15:04:05.999999999"}, updatedAt, updatedAt,
+					"2024-10-01 12:30:00.0000005", "2024-10-01 12:30:00"},
+				{CursorConfig{Type: "timestamptz", TimeFormat: "unixmicro"}, updatedAtMicro, updatedAtMicro,
+					"1727785800000000", "1727785799999999"},
+				{CursorConfig{Type: "timestamptz", TimeFormat: "unix"}, updatedAt, updatedAt, "1727785800.0000005",
+					"1727785799"},
+				{CursorConfig{Type: "timestamp", TimeFormat: "unixmilli"}, updatedAt, updatedAt, "1727785800000.0005",
+					"1727785799999.9"},
+			}
+
+			for _, test := range tests {
+				It(fmt.Sprintf("should handle %s values stored as '%s'", test.cursor.Type, test.stored), func() {
+					c := test.cursor
+					c.Column = "col"
+					c.Default = test.previous
+					info, err := c.Info()
+					Expect(err).NotTo(HaveOccurred())
+
+					value, err := info.Value(map[string]any{"col": test.dbValue})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(value).To(Equal(test.value))
+					Expect(info.Format(value)).To(Equal(test.stored))
+					Expect(info.ConvertFunc(test.stored)).To(Equal(test.value))
+					Expect(info.CompareFunc(info.Default, value)).To(Equal(-1))
+					Expect(info.CompareFunc(value, value)).To(Equal(0))
+				})
+			}
+		})
+
+		It("should fail when the time format drops the nanoseconds", func() {
+			for _, timeFormat := range []string{time.RFC3339, "2006-01-02 15:04:05.000", "15:04:05.999999999"} {
+				_, err := (&CursorConfig{Column: "a", Type: "timestamp", TimeFormat: timeFormat}).Info()
+				Expect(err).To(MatchError(ContainSubstring("should keep the nanoseconds")), timeFormat)
+			}
+		})
+
+		It("should fail when the values don't match the columns", func() {
+			for _, c := range []CursorConfig{
+				{Column: "", Type: "int64", Default: "-1"},
+				{Column: "a,b", Type: "int64,int64,int64", Default: "-1"},
+				{Column: "a,b", Type: "int64", Default: "1,2,3"},
+				{Column: "a,b", Type: "int64", Default: "1,x"},
+			} {
+				_, err := c.Info()
+				Expect(err).To(HaveOccurred(), "cursor %v", c)
+			}
+
+			info, err := (&CursorConfig{Column: "a,b", Type: "int64", Default: "-1"}).Info()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = info.ConvertFunc(`["1"]`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("LookbackConfig", func() {
+	Describe("Validate()", func() {
+		It("should accept the lookback matching the cursor type", func() {
+			tests := []struct {
+				cursor   CursorConfig
+				lookback LookbackConfig
+			}{
+				{CursorConfig{Column: "id", Type: "int64", Default: "0"}, LookbackConfig{}},
+				{CursorConfig{Column: "a,b", Type: "int64", Default: "0"}, LookbackConfig{}},
+				{CursorConfig{Column: "id", Type: "int", Default: "0"}, LookbackConfig{IDs: 10, GapTimeout: time.Second}},
+				{CursorConfig{Column: "updated_at", Type: "timestamptz", Default: "1970-01-01"},
+					LookbackConfig{Window: time.Minute}},
+			}
+			for _, test := range tests {
+				info, err := test.cursor.Info()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(test.lookback.Validate(info, 100)).To(Succeed(), "lookback %v", test.lookback)
+			}
+		})
+
+		It("should fail when the lookback doesn't match the cursor", func() {
+			tests := []struct {
+				cursor   CursorConfig
+				lookback LookbackConfig
+			}{
+				{CursorConfig{Column: "id", Type: "int64", Default: "0"}, LookbackConfig{IDs: -1}},
+				{CursorConfig{Column: "id", Type: "int64", Default: "0"}, LookbackConfig{IDs: 100}},
+				{CursorConfig{Column: "id", Type: "int64", Default: "0"}, LookbackConfig{IDs: 10, Window: time.Minute}},
+				{CursorConfig{Column: "id", Type: "int64", Default: "0"}, LookbackConfig{Window: time.Minute}},
+				{CursorConfig{Column: "a,b", Type: "int64", Default: "0"}, LookbackConfig{IDs: 10}},
+				{CursorConfig{Column: "id", Type: "uuid", Default: "0"}, LookbackConfig{IDs: 10}},
+			}
+			for _, test := range tests {
+				info, err := test.cursor.Info()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(test.lookback.Validate(info, 100)).NotTo(Succeed(), "lookback %v", test.lookback)
+			}
+		})
+	})
+})
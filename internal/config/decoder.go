@@ -0,0 +1,406 @@
+package config
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column types used to decode the values scanned from the db.
+const (
+	ColumnTypeRaw     = "raw"
+	ColumnTypeText    = "text"
+	ColumnTypeInt     = "int"
+	ColumnTypeFloat   = "float"
+	ColumnTypeNumeric = "numeric"
+	ColumnTypeBool    = "bool"
+	ColumnTypeJSON    = "json"
+	ColumnTypeArray   = "array"
+	ColumnTypeTime    = "time"
+	ColumnTypeHex     = "hex"
+	ColumnTypeBase64  = "base64"
+)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	time.DateOnly,
+}
+
+type (
+	DecodeFunc    func(value any) (any, error)
+	RowDecodeFunc func(row map[string]any) error
+)
+
+// RowDecoder returns a function that decodes in place the values of a scanned row into well-defined types, based on
+// the database type of each column and the column types defined in the config.
+func (c *JobDBConfig) RowDecoder(columnTypes []*sql.ColumnType) (RowDecodeFunc, error) {
+	decoders := make(map[string]DecodeFunc, len(columnTypes))
+	for _, columnType := range columnTypes {
+		name := columnType.Name()
+		typeName, ok := c.ColumnTypes[name]
+		if !ok {
+			decoders[name] = dbTypeDecoder(columnType.DatabaseTypeName())
+			continue
+		}
+
+		decoder, err := columnTypeDecoder(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type for column '%s': %w", name, err)
+		}
+		decoders[name] = decoder
+	}
+
+	return func(row map[string]any) error {
+		for name, decoder := range decoders {
+			value := row[name]
+			if value == nil {
+				continue
+			}
+
+			decoded, err := decoder(value)
+			if err != nil {
+				return fmt.Errorf("unable to decode column '%s': %w", name, err)
+			}
+			row[name] = decoded
+		}
+		return nil
+	}, nil
+}
+
+// ValidateColumnTypes checks that the column types defined in the config are supported.
+func (c *JobDBConfig) ValidateColumnTypes() error {
+	for name, typeName := range c.ColumnTypes {
+		if _, err := columnTypeDecoder(typeName); err != nil {
+			return fmt.Errorf("invalid type for column '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+func columnTypeDecoder(typeName string) (DecodeFunc, error) {
+	switch typeName {
+	case ColumnTypeRaw:
+		return decodeRaw, nil
+	case ColumnTypeText:
+		return decodeText, nil
+	case ColumnTypeInt:
+		return decodeInt, nil
+	case ColumnTypeFloat:
+		return decodeFloat, nil
+	case ColumnTypeNumeric:
+		return decodeNumeric, nil
+	case ColumnTypeBool:
+		return decodeBool, nil
+	case ColumnTypeJSON:
+		return decodeJSON, nil
+	case ColumnTypeArray:
+		return arrayDecoder(decodeText), nil
+	case ColumnTypeTime:
+		return decodeTime, nil
+	case ColumnTypeHex:
+		return decodeHex, nil
+	case ColumnTypeBase64:
+		return decodeBase64, nil
+	}
+
+	return nil, fmt.Errorf("unsupported column type: %s", typeName)
+}
+
+// dbTypeDecoder returns the decoder for the database type name reported by the driver.
+func dbTypeDecoder(dbTypeName string) DecodeFunc {
+	dbTypeName = strings.ToUpper(dbTypeName)
+	if elementType, ok := strings.CutPrefix(dbTypeName, "_"); ok {
+		// Postgres arrays are reported with the element type prefixed by an underscore
+		return arrayDecoder(dbTypeDecoder(elementType))
+	}
+
+	switch dbTypeName {
+	case "INT2", "INT4", "INT8", "SMALLINT", "INT", "INTEGER", "BIGINT", "TINYINT", "MEDIUMINT":
+		return decodeInt
+	case "FLOAT4", "FLOAT8", "REAL", "FLOAT", "DOUBLE":
+		return decodeFloat
+	case "NUMERIC", "DECIMAL":
+		return decodeNumeric
+	case "BOOL", "BOOLEAN":
+		return decodeBool
+	case "JSON", "JSONB":
+		return decodeJSON
+	case "TIMESTAMP", "TIMESTAMPTZ", "DATE", "DATETIME", "DATETIME2", "DATETIMEOFFSET", "SMALLDATETIME":
+		return decodeTime
+	case "BYTEA", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "IMAGE":
+		return decodeHex
+	}
+
+	return decodeBytes
+}
+
+func decodeRaw(value any) (any, error) {
+	return value, nil
+}
+
+// decodeBytes converts the bytes returned by the driver for text-like types into strings.
+func decodeBytes(value any) (any, error) {
+	if v, ok := value.([]byte); ok {
+		return string(v), nil
+	}
+	return value, nil
+}
+
+func decodeText(value any) (any, error) {
+	switch v := value.(type) {
+	case []byte:
+		return string(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case string:
+		return v, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func decodeInt(value any) (any, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case float64:
+		return int64(v), nil
+	}
+	return value, nil
+}
+
+func decodeFloat(value any) (any, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case int64:
+		return float64(v), nil
+	}
+	return value, nil
+}
+
+// decodeNumeric decodes exact numeric values as json.Number to avoid losing precision.
+func decodeNumeric(value any) (any, error) {
+	var text string
+	switch v := value.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return value, nil
+	}
+
+	if _, err := strconv.ParseFloat(text, 64); err != nil {
+		return nil, fmt.Errorf("invalid numeric value '%s'", text)
+	}
+	return json.Number(text), nil
+}
+
+func decodeBool(value any) (any, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case []byte:
+		return parseBool(string(v))
+	case string:
+		return parseBool(v)
+	}
+	return value, nil
+}
+
+func parseBool(value string) (bool, error) {
+	switch value {
+	case "t", "\x01":
+		return true, nil
+	case "f", "\x00":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func decodeJSON(value any) (any, error) {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return value, nil
+	}
+
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("invalid json value")
+	}
+	return json.RawMessage(data), nil
+}
+
+func decodeTime(value any) (any, error) {
+	var text string
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return value, nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time value '%s'", text)
+}
+
+func decodeHex(value any) (any, error) {
+	if v, ok := value.([]byte); ok {
+		return hex.EncodeToString(v), nil
+	}
+	return value, nil
+}
+
+func decodeBase64(value any) (any, error) {
+	if v, ok := value.([]byte); ok {
+		return base64.StdEncoding.EncodeToString(v), nil
+	}
+	return value, nil
+}
+
+// arrayDecoder returns a decoder of Postgres array literals (e.g. {1,2,"a b"}) into slices, decoding each element
+// with the element decoder.
+func arrayDecoder(elementDecoder DecodeFunc) DecodeFunc {
+	return func(value any) (any, error) {
+		var text string
+		switch v := value.(type) {
+		case []byte:
+			text = string(v)
+		case string:
+			text = v
+		default:
+			return value, nil
+		}
+
+		result, rest, err := parseArray(text, elementDecoder)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" {
+			return nil, fmt.Errorf("invalid array value '%s'", text)
+		}
+		return result, nil
+	}
+}
+
+func parseArray(text string, elementDecoder DecodeFunc) ([]any, string, error) {
+	if !strings.HasPrefix(text, "{") {
+		return nil, "", fmt.Errorf("invalid array value '%s'", text)
+	}
+	text = text[1:]
+
+	result := make([]any, 0)
+	for len(text) > 0 {
+		var (
+			element any
+			err     error
+		)
+
+		switch text[0] {
+		case '}':
+			return result, text[1:], nil
+		case ',':
+			text = text[1:]
+			continue
+		case '{':
+			element, text, err = parseArray(text, elementDecoder)
+		case '"':
+			var value string
+			value, text, err = parseQuotedElement(text)
+			if err == nil {
+				element, err = elementDecoder(value)
+			}
+		default:
+			end := strings.IndexAny(text, ",}")
+			if end == -1 {
+				return nil, "", fmt.Errorf("unterminated array")
+			}
+			value := text[:end]
+			text = text[end:]
+			if value != "NULL" {
+				element, err = elementDecoder(value)
+			}
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+		result = append(result, element)
+	}
+
+	return nil, "", fmt.Errorf("unterminated array")
+}
+
+func parseQuotedElement(text string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+			if i < len(text) {
+				b.WriteByte(text[i])
+			}
+		case '"':
+			return b.String(), text[i+1:], nil
+		default:
+			b.WriteByte(text[i])
+		}
+	}
+
+	return "", "", fmt.Errorf("unterminated quoted array element")
+}
+
+// formatValue converts the decoded values into the representation used in templates and redis commands.
+func formatValue(value any) any {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case json.Number:
+		return string(v)
+	case json.RawMessage:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []any:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+	return value
+}
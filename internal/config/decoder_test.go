@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("Decoder", func() {
+	Describe("dbTypeDecoder()", func() {
+		ts := time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+		tests := []struct {
+			dbType   string
+			value    any
+			expected any
+		}{
+			{"TEXT", []byte("hello"), "hello"},
+			{"VARCHAR", "hello", "hello"},
+			{"UUID", []byte("8afb5e31-d8a6-4d92-b964-6ad8cc296050"), "8afb5e31-d8a6-4d92-b964-6ad8cc296050"},
+			{"INT8", int64(10), int64(10)},
+			{"INT", []byte("10"), int64(10)},
+			{"FLOAT8", []byte("1.5"), 1.5},
+			{"NUMERIC", []byte("12345678901234567890.123"), json.Number("12345678901234567890.123")},
+			{"BOOL", []byte("t"), true},
+			{"JSONB", []byte(`{"a":1}`), json.RawMessage(`{"a":1}`)},
+			{"TIMESTAMPTZ", ts, ts},
+			{"DATETIME", []byte("2024-10-01 12:30:00"), ts},
+			{"BYTEA", []byte{0xca, 0xfe}, "cafe"},
+			{"_INT4", []byte("{1,2,NULL}"), []any{int64(1), int64(2), nil}},
+			{"_TEXT", []byte(`{a,"b c","d\"e",NULL,"NULL"}`), []any{"a", "b c", `d"e`, nil, "NULL"}},
+			{"_INT8", []byte("{{1,2},{3,4}}"), []any{[]any{int64(1), int64(2)}, []any{int64(3), int64(4)}}},
+			{"", int64(1), int64(1)},
+		}
+
+		for _, test := range tests {
+			It("should decode "+test.dbType, func() {
+				decoded, err := dbTypeDecoder(test.dbType)(test.value)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decoded).To(Equal(test.expected))
+			})
+		}
+
+		It("should fail with invalid values", func() {
+			for _, test := range [][]any{{"INT8", []byte("a")}, {"JSON", []byte("{")}, {"_TEXT", []byte("{a,b")}} {
+				_, err := dbTypeDecoder(test[0].(string))(test[1])
+				Expect(err).To(HaveOccurred(), "type %s", test[0])
+			}
+		})
+	})
+
+	Describe("columnTypeDecoder()", func() {
+		It("should decode using the column type", func() {
+			decoder, err := columnTypeDecoder(ColumnTypeText)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoder(int64(1))).To(Equal("1"))
+
+			decoder, err = columnTypeDecoder(ColumnTypeBase64)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoder([]byte{0xca, 0xfe})).To(Equal("yv4="))
+
+			decoder, err = columnTypeDecoder(ColumnTypeRaw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoder([]byte("a"))).To(Equal([]byte("a")))
+		})
+
+		It("should fail with unsupported types", func() {
+			_, err := columnTypeDecoder("xml")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("formatted templates", func() {
+		logger, err := zap.NewDevelopment()
+		Expect(err).NotTo(HaveOccurred())
+
+		It("should format the decoded values", func() {
+			c := JobRedisConfig{Key: "k:${name}:${amount}:${ts}:${tags}"}
+			fn, err := c.KeyFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(map[string]any{
+				"name":   []byte("hel"),
+				"amount": json.Number("1.50"),
+				"ts":     time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC),
+				"tags":   []any{"a", "b"},
+			})).To(Equal(`k:hel:1.50:2024-10-01T12:30:00Z:["a","b"]`))
+		})
+	})
+})
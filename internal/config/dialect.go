@@ -0,0 +1,325 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Names of the supported database drivers.
+const (
+	DriverPostgres  = "postgres"
+	DriverMySQL     = "mysql"
+	DriverSQLite    = "sqlite3"
+	DriverSQLServer = "sqlserver"
+)
+
+// Dialect contains the database specific behavior: how to build the connection string, the placeholder style of the
+// query parameters and how to limit the number of rows returned by a query.
+type Dialect interface {
+	// ConnectionString returns the connection string built from the db config.
+	ConnectionString(c *DBConfig) (string, error)
+	// Placeholder returns the placeholder of the query parameter at the index, starting from 1.
+	Placeholder(index int) string
+	// Positional returns true when the placeholders are bound by position, so they can't be repeated.
+	Positional() bool
+	// Limit returns the query limiting the number of rows.
+	Limit(query string, limit int) string
+	// Upsert returns the statement inserting or updating a row by the first column, with a placeholder per column.
+	Upsert(table string, columns ...string) string
+}
+
+var dialects = map[string]Dialect{
+	DriverPostgres:  postgresDialect{},
+	DriverMySQL:     mysqlDialect{},
+	DriverSQLite:    sqliteDialect{},
+	DriverSQLServer: sqlServerDialect{},
+}
+
+var (
+	placeholderRegex      = regexp.MustCompile(`^\$(\d+)`)
+	namedPlaceholderRegex = regexp.MustCompile(`^:([a-zA-Z_][a-zA-Z0-9_]*)`)
+	orderByRegex          = regexp.MustCompile(`(?is)\border\s+by\s+[^()]+$`)
+	selectRegex           = regexp.MustCompile(`(?i)^\s*select(\s+distinct)?\b`)
+)
+
+// Dialect returns the dialect of the driver.
+func (c *DBConfig) Dialect() (Dialect, error) {
+	d, ok := dialects[c.DriverName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported db driver: %s", c.DriverName)
+	}
+	return d, nil
+}
+
+// Rebind replaces the $1, $2, ... placeholders in the query with the placeholders of the dialect, ignoring quoted
+// text.
+func Rebind(d Dialect, query string) (string, error) {
+	used := make(map[string]bool)
+	return scanQuery(query, func(query string, i int) (string, int, error) {
+		match := placeholderRegex.FindStringSubmatch(query[i:])
+		if match == nil {
+			return "", 0, nil
+		}
+		if used[match[1]] && d.Positional() {
+			return "", 0, fmt.Errorf("placeholder %s can only be used once with this db driver", match[0])
+		}
+		used[match[1]] = true
+		index, _ := strconv.Atoi(match[1])
+		return d.Placeholder(index), len(match[0]), nil
+	})
+}
+
+// BindNamed replaces the named placeholders in the query (e.g. :cursor) with the placeholders of the dialect,
+// ignoring quoted text and casts (e.g. ::text). Returns the names of the parameters in the order of the query
+// arguments, or nil when the query doesn't contain named placeholders.
+func BindNamed(d Dialect, query string) (string, []string, error) {
+	var names []string
+	indexes := make(map[string]int)
+	result, err := scanQuery(query, func(query string, i int) (string, int, error) {
+		match := namedPlaceholderRegex.FindStringSubmatch(query[i:])
+		if match == nil || (i > 0 && query[i-1] == ':') {
+			return "", 0, nil
+		}
+
+		name := match[1]
+		index, ok := indexes[name]
+		if !ok || d.Positional() {
+			names = append(names, name)
+			index = len(names)
+			indexes[name] = index
+		}
+		return d.Placeholder(index), len(match[0]), nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return result, names, nil
+}
+
+// scanQuery builds a query by calling replace on each position outside quoted text and comments. The replace
+// function returns the replacement and the number of bytes replaced, or 0 to keep the byte at the position.
+func scanQuery(query string, replace func(query string, i int) (string, int, error)) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(query); {
+		var end string
+		switch {
+		case query[i] == '\'' || query[i] == '"' || query[i] == '`':
+			end = query[i : i+1]
+		case strings.HasPrefix(query[i:], "--"):
+			end = "\n"
+		case strings.HasPrefix(query[i:], "/*"):
+			end = "*/"
+		}
+
+		if end != "" {
+			// Copy the quoted text or comment as is
+			n := strings.Index(query[i+1:], end)
+			if n == -1 {
+				b.WriteString(query[i:])
+				return b.String(), nil
+			}
+			n += 1 + len(end)
+			b.WriteString(query[i : i+n])
+			i += n
+			continue
+		}
+
+		switch query[i] {
+		case '$', ':':
+			replacement, n, err := replace(query, i)
+			if err != nil {
+				return "", err
+			}
+			if n > 0 {
+				b.WriteString(replacement)
+				i += n
+				continue
+			}
+		}
+		b.WriteByte(query[i])
+		i++
+	}
+	return b.String(), nil
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) ConnectionString(c *DBConfig) (string, error) {
+	result := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		c.Host, c.Port, c.User, c.Password, c.DBName, c.TLS.Mode)
+
+	if c.TLS.RootCert != "" {
+		result += fmt.Sprintf(" sslrootcert=%s", c.TLS.RootCert)
+	}
+
+	return result, nil
+}
+
+func (postgresDialect) Placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+func (postgresDialect) Positional() bool {
+	return false
+}
+
+func (postgresDialect) Limit(query string, limit int) string {
+	return fmt.Sprintf("%s LIMIT %d", query, limit)
+}
+
+func (d postgresDialect) Upsert(table string, columns ...string) string {
+	return insertOnConflict(d, table, columns)
+}
+
+// insertOnConflict returns the upsert statement of the dialects supporting INSERT ... ON CONFLICT.
+func insertOnConflict(d Dialect, table string, columns []string) string {
+	updates := make([]string, len(columns)-1)
+	for i, column := range columns[1:] {
+		updates[i] = fmt.Sprintf("%s = excluded.%s", column, column)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, strings.Join(columns, ", "), placeholders(d, len(columns)), columns[0], strings.Join(updates, ", "))
+}
+
+// placeholders returns the comma-separated placeholders of the first n query parameters.
+func placeholders(d Dialect, n int) string {
+	result := make([]string, n)
+	for i := range result {
+		result[i] = d.Placeholder(i + 1)
+	}
+	return strings.Join(result, ", ")
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) ConnectionString(c *DBConfig) (string, error) {
+	params := url.Values{"parseTime": {"true"}}
+	switch c.TLS.Mode {
+	case "", "disable":
+	case "require":
+		params.Set("tls", "skip-verify")
+	case "verify-ca", "verify-full":
+		params.Set("tls", "true")
+	default:
+		return "", fmt.Errorf("unsupported tls mode: %s", c.TLS.Mode)
+	}
+	if c.TLS.RootCert != "" {
+		return "", errors.New("tls root cert is not supported by the mysql driver, use a connection string instead")
+	}
+
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s", c.User, c.Password, c.Host, c.Port, c.DBName, params.Encode()), nil
+}
+
+func (mysqlDialect) Placeholder(_ int) string {
+	return "?"
+}
+
+func (mysqlDialect) Positional() bool {
+	return true
+}
+
+func (mysqlDialect) Limit(query string, limit int) string {
+	return fmt.Sprintf("%s LIMIT %d", query, limit)
+}
+
+func (d mysqlDialect) Upsert(table string, columns ...string) string {
+	updates := make([]string, len(columns)-1)
+	for i, column := range columns[1:] {
+		updates[i] = fmt.Sprintf("%s = VALUES(%s)", column, column)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+		table, strings.Join(columns, ", "), placeholders(d, len(columns)), strings.Join(updates, ", "))
+}
+
+type sqliteDialect struct{}
+
+// ConnectionString returns the db name as the path of the database file.
+func (sqliteDialect) ConnectionString(c *DBConfig) (string, error) {
+	return c.DBName, nil
+}
+
+func (sqliteDialect) Placeholder(index int) string {
+	return "?" + strconv.Itoa(index)
+}
+
+func (sqliteDialect) Positional() bool {
+	return false
+}
+
+func (sqliteDialect) Limit(query string, limit int) string {
+	return fmt.Sprintf("%s LIMIT %d", query, limit)
+}
+
+func (d sqliteDialect) Upsert(table string, columns ...string) string {
+	return insertOnConflict(d, table, columns)
+}
+
+type sqlServerDialect struct{}
+
+func (sqlServerDialect) ConnectionString(c *DBConfig) (string, error) {
+	params := url.Values{"database": {c.DBName}}
+	switch c.TLS.Mode {
+	case "", "disable":
+		params.Set("encrypt", "disable")
+	case "require":
+		params.Set("encrypt", "true")
+		params.Set("TrustServerCertificate", "true")
+	case "verify-ca", "verify-full":
+		params.Set("encrypt", "true")
+	default:
+		return "", fmt.Errorf("unsupported tls mode: %s", c.TLS.Mode)
+	}
+	if c.TLS.RootCert != "" {
+		params.Set("certificate", c.TLS.RootCert)
+	}
+
+	u := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+		RawQuery: params.Encode(),
+	}
+	return u.String(), nil
+}
+
+func (sqlServerDialect) Placeholder(index int) string {
+	return "@p" + strconv.Itoa(index)
+}
+
+func (sqlServerDialect) Positional() bool {
+	return false
+}
+
+// Limit uses OFFSET/FETCH when the query is ordered, otherwise TOP.
+func (sqlServerDialect) Limit(query string, limit int) string {
+	if orderByRegex.MatchString(query) {
+		return fmt.Sprintf("%s OFFSET 0 ROWS FETCH NEXT %d ROWS ONLY", query, limit)
+	}
+
+	loc := selectRegex.FindStringIndex(query)
+	if loc == nil {
+		return query
+	}
+	return fmt.Sprintf("%s TOP (%d)%s", query[:loc[1]], limit, query[loc[1]:])
+}
+
+func (d sqlServerDialect) Upsert(table string, columns ...string) string {
+	source := make([]string, len(columns))
+	updates := make([]string, len(columns)-1)
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		source[i] = fmt.Sprintf("%s AS %s", d.Placeholder(i+1), column)
+		values[i] = "source." + column
+		if i > 0 {
+			updates[i-1] = fmt.Sprintf("%s = source.%s", column, column)
+		}
+	}
+	return fmt.Sprintf("MERGE %s AS target USING (SELECT %s) AS source ON target.%s = source.%s "+
+		"WHEN MATCHED THEN UPDATE SET %s WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);",
+		table, strings.Join(source, ", "), columns[0], columns[0], strings.Join(updates, ", "),
+		strings.Join(columns, ", "), strings.Join(values, ", "))
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dialect", func() {
+	query := "SELECT id, '$1' AS text FROM users WHERE id > $1 AND tenant = $2"
+
+	Describe("Rebind()", func() {
+		tests := []struct {
+			driver   string
+			expected string
+		}{
+			{DriverPostgres, "SELECT id, '$1' AS text FROM users WHERE id > $1 AND tenant = $2"},
+			{DriverMySQL, "SELECT id, '$1' AS text FROM users WHERE id > ? AND tenant = ?"},
+			{DriverSQLite, "SELECT id, '$1' AS text FROM users WHERE id > ?1 AND tenant = ?2"},
+			{DriverSQLServer, "SELECT id, '$1' AS text FROM users WHERE id > @p1 AND tenant = @p2"},
+		}
+
+		for _, test := range tests {
+			It("should use the placeholders of "+test.driver, func() {
+				c := DBConfig{DriverName: test.driver}
+				d, err := c.Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(Rebind(d, query)).To(Equal(test.expected))
+			})
+		}
+
+		It("should ignore the placeholders in comments", func() {
+			Expect(Rebind(mysqlDialect{}, "SELECT id /* $2 */ FROM users -- $3\nWHERE id > $1")).To(
+				Equal("SELECT id /* $2 */ FROM users -- $3\nWHERE id > ?"))
+		})
+
+		It("should fail when a placeholder is repeated with positional placeholders", func() {
+			_, err := Rebind(mysqlDialect{}, "SELECT id FROM users WHERE id > $1 OR id < $1")
+			Expect(err).To(HaveOccurred())
+
+			Expect(Rebind(postgresDialect{}, "SELECT id FROM users WHERE id > $1 OR id < $1")).To(
+				Equal("SELECT id FROM users WHERE id > $1 OR id < $1"))
+		})
+	})
+
+	Describe("Limit()", func() {
+		It("should append LIMIT", func() {
+			for _, d := range []Dialect{postgresDialect{}, mysqlDialect{}, sqliteDialect{}} {
+				Expect(d.Limit("SELECT id FROM users", 10)).To(Equal("SELECT id FROM users LIMIT 10"))
+			}
+		})
+
+		It("should use TOP or FETCH with sqlserver", func() {
+			d := sqlServerDialect{}
+			Expect(d.Limit("SELECT id FROM users", 10)).To(Equal("SELECT TOP (10) id FROM users"))
+			Expect(d.Limit("select distinct id FROM users", 10)).To(Equal("select distinct TOP (10) id FROM users"))
+			Expect(d.Limit("SELECT id FROM users ORDER BY id", 10)).To(
+				Equal("SELECT id FROM users ORDER BY id OFFSET 0 ROWS FETCH NEXT 10 ROWS ONLY"))
+		})
+	})
+
+	Describe("Upsert()", func() {
+		tests := []struct {
+			d        Dialect
+			expected string
+		}{
+			{postgresDialect{}, "INSERT INTO cursors (k, v) VALUES ($1, $2) ON CONFLICT (k) DO UPDATE SET v = excluded.v"},
+			{sqliteDialect{}, "INSERT INTO cursors (k, v) VALUES (?1, ?2) ON CONFLICT (k) DO UPDATE SET v = excluded.v"},
+			{mysqlDialect{}, "INSERT INTO cursors (k, v) VALUES (?, ?) ON DUPLICATE KEY UPDATE v = VALUES(v)"},
+			{sqlServerDialect{}, "MERGE cursors AS target USING (SELECT @p1 AS k, @p2 AS v) AS source ON target.k = source.k " +
+				"WHEN MATCHED THEN UPDATE SET v = source.v WHEN NOT MATCHED THEN INSERT (k, v) VALUES (source.k, source.v);"},
+		}
+
+		for _, test := range tests {
+			It(fmt.Sprintf("should build the upsert statement of %T", test.d), func() {
+				Expect(test.d.Upsert("cursors", "k", "v")).To(Equal(test.expected))
+			})
+		}
+	})
+
+	Describe("ConnectionString()", func() {
+		c := DBConfig{
+			Host:     "db",
+			Port:     1234,
+			User:     "worker",
+			Password: "secret",
+			DBName:   "app",
+			TLS:      DBTLSConfig{Mode: "disable"},
+		}
+
+		tests := []struct {
+			driver   string
+			expected string
+		}{
+			{DriverPostgres, "host=db port=1234 user=worker password=secret dbname=app sslmode=disable"},
+			{DriverMySQL, "worker:secret@tcp(db:1234)/app?parseTime=true"},
+			{DriverSQLite, "app"},
+			{DriverSQLServer, "sqlserver://worker:secret@db:1234?database=app&encrypt=disable"},
+		}
+
+		for _, test := range tests {
+			It("should build the connection string of "+test.driver, func() {
+				c := c
+				c.DriverName = test.driver
+				Expect(c.BuildConnectionString()).To(Equal(test.expected))
+			})
+		}
+
+		It("should fail with unsupported drivers", func() {
+			c := DBConfig{DriverName: "oracle"}
+			_, err := c.BuildConnectionString()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
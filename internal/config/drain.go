@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// DefaultTargetLatency is the default latency of a batch (query and pipeline) targeted by the adaptive batch size.
+const DefaultTargetLatency = time.Second
+
+// DrainConfig defines how the runner catches up when the batches are full: polling again without waiting for the
+// poll delay and, optionally, adapting the batch size to the latency of the batches.
+type DrainConfig struct {
+	// Enabled polls again immediately while the batches are full, instead of waiting for the poll delay.
+	Enabled bool `yaml:"enabled" env:"ENABLED"`
+
+	// MaxRowsPerSecond bounds the catch-up rate while draining. When 0, it's not bounded.
+	MaxRowsPerSecond int `yaml:"maxRowsPerSecond" env:"MAX_ROWS_PER_SECOND"`
+
+	// MinBatchSize and MaxBatchSize enable the adaptive batch size when MaxBatchSize is set: the batch size grows
+	// while full batches are processed under the target latency and shrinks when over it. The select query should
+	// limit the rows using :batch_size.
+	MinBatchSize int `yaml:"minBatchSize" env:"MIN_BATCH_SIZE"`
+	MaxBatchSize int `yaml:"maxBatchSize" env:"MAX_BATCH_SIZE"`
+
+	// TargetLatency is the latency of a batch targeted by the adaptive batch size, 1s by default.
+	TargetLatency time.Duration `yaml:"targetLatency" env:"TARGET_LATENCY"`
+}
+
+// Adaptive returns true when the adaptive batch size is enabled.
+func (c *DrainConfig) Adaptive() bool {
+	return c.MaxBatchSize > 0
+}
+
+// validateDrain checks the drain settings of the job, once the select query is bound.
+func validateDrain(job *JobConfig) error {
+	c := &job.Drain
+	switch {
+	case c.MaxRowsPerSecond < 0:
+		return errors.New("drain max rows per second should not be negative")
+	case !c.Adaptive():
+		return nil
+	case c.MinBatchSize < 0 || c.MinBatchSize > c.MaxBatchSize:
+		return errors.New("drain min batch size should be between 0 and the max batch size")
+	case c.TargetLatency < 0:
+		return errors.New("drain target latency should not be negative")
+	case !slices.Contains(job.DB.queryParams, ParamBatchSize):
+		return errors.New("adaptive batch size requires a select query limiting the rows using :batch_size")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Expiration is the expiration of a key, either relative to the time of the write (TTL) or absolute (At).
+// The zero value means that the key does not expire.
+type Expiration struct {
+	TTL time.Duration
+	At  time.Time
+}
+
+type ExpirationFunc func(row map[string]any) (Expiration, error)
+
+// IsZero returns true when the key does not expire.
+func (e Expiration) IsZero() bool {
+	return e.TTL == 0 && e.At.IsZero()
+}
+
+// ExpirationFn returns a function that computes the expiration of the key of a row, based on the TTL setting:
+// a fixed duration or a template referencing a column containing an absolute time or a number of seconds. Returns
+// ErrKeyExpired when the time of the column is in the past or the number of seconds is lower or equal to 0.
+func (c *JobRedisConfig) ExpirationFn(logger *zap.Logger) (ExpirationFunc, error) {
+	jitter := c.jitterFn()
+	if c.TTL == "" {
+		return func(_ map[string]any) (Expiration, error) {
+			return Expiration{}, nil
+		}, nil
+	}
+
+	if !strings.Contains(c.TTL, "${") {
+		ttl, err := time.ParseDuration(c.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl '%s': %w", c.TTL, err)
+		}
+		if ttl <= 0 {
+			return nil, fmt.Errorf("ttl should be greater than 0: %s", c.TTL)
+		}
+
+		logger.Info("Using fixed redis ttl", zap.Duration("ttl", ttl), zap.Duration("jitter", c.TTLJitter))
+		return func(_ map[string]any) (Expiration, error) {
+			return Expiration{TTL: ttl + jitter()}, nil
+		}, nil
+	}
+
+	t, err := parseTemplate(c.TTL)
+	if err != nil {
+		return nil, err
+	}
+	segment, ok := t.single()
+	if !ok || len(segment.columns) != 1 {
+		return nil, fmt.Errorf("ttl template should reference a single column: %s", c.TTL)
+	}
+	column := segment.columns[0]
+
+	logger.Info("Using redis ttl from column", zap.String("column", column), zap.Duration("jitter", c.TTLJitter))
+
+	return func(row map[string]any) (Expiration, error) {
+		value, err := segment.eval(row)
+		if err != nil {
+			return Expiration{}, err
+		}
+		expiration, err := toExpiration(value)
+		if errors.Is(err, ErrKeyExpired) {
+			return Expiration{}, err
+		}
+		if err != nil {
+			return Expiration{}, fmt.Errorf("invalid ttl value for column '%s': %w", column, err)
+		}
+		if expiration.IsZero() {
+			return expiration, nil
+		}
+		if !expiration.At.IsZero() && !expiration.At.After(time.Now()) {
+			// The jitter doesn't postpone an expired key
+			return Expiration{}, ErrKeyExpired
+		}
+
+		if expiration.At.IsZero() {
+			expiration.TTL += jitter()
+		} else {
+			expiration.At = expiration.At.Add(jitter())
+		}
+		return expiration, nil
+	}, nil
+}
+
+func (c *JobRedisConfig) jitterFn() func() time.Duration {
+	if c.TTLJitter <= 0 {
+		return func() time.Duration {
+			return 0
+		}
+	}
+
+	return func() time.Duration {
+		return rand.N(c.TTLJitter) //nolint:gosec
+	}
+}
+
+// toExpiration converts the value of a column to an expiration: time values are absolute and numeric values are
+// considered a number of seconds.
+func toExpiration(value any) (Expiration, error) {
+	switch v := value.(type) {
+	case nil:
+		return Expiration{}, nil
+	case time.Time:
+		return Expiration{At: v}, nil
+	case int64:
+		return secondsExpiration(float64(v))
+	case int32:
+		return secondsExpiration(float64(v))
+	case int:
+		return secondsExpiration(float64(v))
+	case uint64:
+		return secondsExpiration(float64(v))
+	case float64:
+		return secondsExpiration(v)
+	case json.Number:
+		// Numeric and decimal columns
+		return parseExpiration(v.String())
+	case []byte:
+		return parseExpiration(string(v))
+	case string:
+		return parseExpiration(v)
+	}
+
+	return Expiration{}, fmt.Errorf("unsupported type %T", value)
+}
+
+func parseExpiration(value string) (Expiration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return secondsExpiration(seconds)
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return Expiration{}, fmt.Errorf("expected a number of seconds or a RFC3339 time: %w", err)
+	}
+	return Expiration{At: at}, nil
+}
+
+func secondsExpiration(seconds float64) (Expiration, error) {
+	if seconds <= 0 {
+		return Expiration{}, ErrKeyExpired
+	}
+
+	return Expiration{TTL: time.Duration(seconds * float64(time.Second))}, nil
+}
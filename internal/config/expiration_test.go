@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("JobRedisConfig", func() {
+	logger, err := zap.NewDevelopment()
+	Expect(err).NotTo(HaveOccurred())
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	row := map[string]any{
+		"id":           1,
+		"expires_at":   expiresAt,
+		"seconds":      int64(90),
+		"text":         []byte("2030-01-02T03:04:05Z"),
+		"empty":        nil,
+		"numeric":      json.Number("90.5"),
+		"unsigned":     uint64(90),
+		"zero":         int64(0),
+		"negative":     json.Number("-1"),
+		"expired":      time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"expired_text": []byte("2020-01-02T03:04:05Z"),
+	}
+
+	Describe("ExpirationFn()", func() {
+		It("should return no expiration when ttl is not set", func() {
+			c := JobRedisConfig{}
+			fn, err := c.ExpirationFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(row)).To(Equal(Expiration{}))
+		})
+
+		It("should return a fixed ttl", func() {
+			c := JobRedisConfig{TTL: "1h"}
+			fn, err := c.ExpirationFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(row)).To(Equal(Expiration{TTL: time.Hour}))
+		})
+
+		It("should add a random jitter to the ttl", func() {
+			c := JobRedisConfig{TTL: "1h", TTLJitter: time.Minute}
+			fn, err := c.ExpirationFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			for range 10 {
+				expiration, err := fn(row)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expiration.TTL).To(BeNumerically(">=", time.Hour))
+				Expect(expiration.TTL).To(BeNumerically("<", time.Hour+time.Minute))
+			}
+		})
+
+		tests := []struct {
+			text     string
+			expected Expiration
+		}{
+			{"${expires_at}", Expiration{At: expiresAt}},
+			{"${seconds}", Expiration{TTL: 90 * time.Second}},
+			{"${text}", Expiration{At: expiresAt}},
+			{"${empty}", Expiration{}},
+			{"${numeric}", Expiration{TTL: 90500 * time.Millisecond}},
+			{"${unsigned}", Expiration{TTL: 90 * time.Second}},
+		}
+
+		for _, test := range tests {
+			It("should return the expiration from the column for "+test.text, func() {
+				c := JobRedisConfig{TTL: test.text}
+				fn, err := c.ExpirationFn(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fn(row)).To(Equal(test.expected))
+			})
+		}
+
+		It("should return that the key is expired for past times and non-positive seconds", func() {
+			for _, ttl := range []string{"${zero}", "${negative}", "${expired}", "${expired_text}"} {
+				c := JobRedisConfig{TTL: ttl, TTLJitter: time.Hour}
+				fn, err := c.ExpirationFn(logger)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = fn(row)
+				Expect(err).To(Equal(ErrKeyExpired), "ttl %s", ttl)
+			}
+		})
+
+		It("should fail when the ttl is not valid", func() {
+			for _, ttl := range []string{"1 hour", "-1s", "exp:${expires_at}", "${seconds}${id}"} {
+				c := JobRedisConfig{TTL: ttl}
+				_, err := c.ExpirationFn(logger)
+				Expect(err).To(HaveOccurred(), "ttl %s", ttl)
+			}
+		})
+
+		It("should fail when the column value is not valid", func() {
+			c := JobRedisConfig{TTL: "${id}"}
+			fn, err := c.ExpirationFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = fn(map[string]any{"id": "tomorrow"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var generationNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// GenerationConfig enables blue/green rebuilds of the cache: the keys of the job are prefixed by the generation name
+// and consumers read the name of the current generation from the pointer key. Once the backfill of a new generation
+// completes, the pointer is flipped to it, the jobs of the previous generation stop writing and its keys expire after
+// the delete delay.
+type GenerationConfig struct {
+	// Name is the generation written by the job (e.g. "users-v2"), the keys are written as "<name>:<key>". It should
+	// be unique, the keys of the previous generation are found by the key patterns registered by its jobs.
+	Name string `yaml:"name" env:"NAME"`
+
+	// PointerKey is the key containing the name of the generation read by the consumers.
+	PointerKey string `yaml:"pointerKey" env:"POINTER_KEY"`
+
+	// DeleteDelay is the time the keys of the previous generation are kept after the flip, so consumers that read the
+	// previous pointer can finish.
+	DeleteDelay time.Duration `yaml:"deleteDelay" env:"DELETE_DELAY" env-default:"5m"`
+
+	// LegacyPatterns are the patterns of the keys written before the generations were enabled (e.g. "users:*"),
+	// expired on the first flip of the pointer. The job writing them doesn't check the retired generations, it should
+	// be stopped before the flip. When empty, the legacy keys are kept.
+	LegacyPatterns []string `yaml:"legacyPatterns" env:"LEGACY_PATTERNS" env-separator:","`
+}
+
+// Prefix returns the prefix of the keys of the generation, empty when not enabled.
+func (c *GenerationConfig) Prefix() string {
+	if c.Name == "" {
+		return ""
+	}
+	return c.Name + ":"
+}
+
+// PatternsKey returns the key of the hash containing the patterns of the keys written by each generation, so the keys
+// of a previous generation are expired without matching the keys of other jobs.
+func (c *GenerationConfig) PatternsKey() string {
+	return c.PointerKey + ":patterns"
+}
+
+// RetiredKey returns the key of the set containing the generations the pointer was flipped from, the jobs of a
+// retired generation stop writing.
+func (c *GenerationConfig) RetiredKey() string {
+	return c.PointerKey + ":retired"
+}
+
+// Validate checks that the name and the pointer key are either both set or empty.
+func (c *GenerationConfig) Validate() error {
+	switch {
+	case c.Name == "" && c.PointerKey == "":
+		return nil
+	case c.Name == "" || c.PointerKey == "":
+		return errors.New("generation name and pointer key should be defined together")
+	case !generationNameRegex.MatchString(c.Name):
+		return fmt.Errorf("invalid generation name '%s', only letters, digits, '_', '.' and '-' are allowed", c.Name)
+	case c.DeleteDelay < 0:
+		return errors.New("generation delete delay should not be negative")
+	}
+	return nil
+}
@@ -1,16 +1,15 @@
 package config
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"os"
-	"regexp"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"go.uber.org/zap"
 )
 
-var limitRegex = regexp.MustCompile(`(?i)\bLIMIT\s+\d+`)
-
 func Load(filename string) (*Config, bool, error) {
 	var c Config
 	fileExists := false
@@ -42,15 +41,195 @@ func Load(filename string) (*Config, bool, error) {
 }
 
 func validate(c *Config) error {
-	if limitRegex.MatchString(c.DB.SelectQuery) {
-		return errors.New("select query should not contain LIMIT")
-	}
-
 	if c.BatchSize <= 0 {
 		return errors.New("batch size should be greater than 0")
 	}
 
-	c.DB.SelectQuery += fmt.Sprintf(" LIMIT %d", c.BatchSize)
+	if len(c.Jobs) == 0 {
+		c.Jobs = []JobConfig{c.DefaultJob()}
+	}
+
+	targetNames := make(map[string]bool, len(c.Redis.Targets))
+	for i, target := range c.Redis.Targets {
+		if target.Name == "" {
+			return fmt.Errorf("redis target at index %d should have a name", i)
+		}
+		if targetNames[target.Name] {
+			return fmt.Errorf("duplicated redis target name '%s'", target.Name)
+		}
+		targetNames[target.Name] = true
+	}
+	for _, target := range c.Redis.AllTargets() {
+		if err := target.ValidateRing(); err != nil {
+			return fmt.Errorf("invalid redis ring: %w", err)
+		}
+	}
+
+	if err := c.CursorStore.Validate(); err != nil {
+		return fmt.Errorf("invalid cursor store: %w", err)
+	}
+
+	dialect, err := c.DB.Dialect()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(c.Jobs))
+	cursorKeys := make(map[string]bool, len(c.Jobs))
+	generations := make(map[string]bool, len(c.Jobs))
+	for i := range c.Jobs {
+		job := &c.Jobs[i]
+		if job.Name == "" {
+			return fmt.Errorf("job at index %d should have a name", i)
+		}
+		if names[job.Name] {
+			return fmt.Errorf("duplicated job name '%s'", job.Name)
+		}
+		names[job.Name] = true
 
+		if err := validateJob(c, dialect, job); err != nil {
+			return fmt.Errorf("job '%s' is not valid: %w", job.Name, err)
+		}
+
+		if cursorKeys[job.Redis.CursorKey] {
+			return fmt.Errorf("job '%s' uses a cursor key already used by another job: %s",
+				job.Name, job.Redis.CursorKey)
+		}
+		cursorKeys[job.Redis.CursorKey] = true
+
+		if cursorKeys[job.DB.Backfill.CheckpointKey] {
+			return fmt.Errorf("job '%s' uses a backfill checkpoint key already used by another job: %s",
+				job.Name, job.DB.Backfill.CheckpointKey)
+		}
+		cursorKeys[job.DB.Backfill.CheckpointKey] = true
+
+		if job.Redis.MarkerKey != "" {
+			if cursorKeys[job.Redis.MarkerKey] {
+				return fmt.Errorf("job '%s' uses a marker key already used as a cursor or marker key: %s",
+					job.Name, job.Redis.MarkerKey)
+			}
+			cursorKeys[job.Redis.MarkerKey] = true
+		}
+
+		if name := job.Redis.Generation.Name; name != "" {
+			if generations[name] {
+				return fmt.Errorf("job '%s' uses a generation already used by another job: %s", job.Name, name)
+			}
+			generations[name] = true
+		}
+	}
+
+	for _, target := range c.Redis.AllTargets() {
+		if !target.Sharded() {
+			continue
+		}
+		for i := range c.Jobs {
+			if err := validateShardedJob(&c.Jobs[i]); err != nil {
+				return fmt.Errorf("job '%s' is not valid with a redis cluster or ring: %w", c.Jobs[i].Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateShardedJob checks that the job doesn't use the settings that are not supported when the keys are spread
+// across the nodes of a redis cluster or ring.
+func validateShardedJob(job *JobConfig) error {
+	if job.Redis.Transactional {
+		return errors.New("transactional batches are not supported, the keys of a batch belong to different nodes")
+	}
+
+	if job.Redis.VersionColumn != "" {
+		tagged, err := job.Redis.KeyHashTagged()
+		if err != nil {
+			return fmt.Errorf("invalid redis key: %w", err)
+		}
+		if !tagged {
+			return errors.New("version column requires a hash tag in the key template (e.g. {user:${id}}), " +
+				"so the key and its version key belong to the same node")
+		}
+	}
 	return nil
 }
+
+func validateJob(c *Config, dialect Dialect, job *JobConfig) error {
+	if job.PollDelay == 0 {
+		job.PollDelay = c.PollDelay
+	}
+	if job.BatchSize == 0 {
+		job.BatchSize = c.BatchSize
+	}
+	if job.BatchSize < 0 {
+		return errors.New("batch size should be greater than 0")
+	}
+	job.Drain = cmp.Or(job.Drain, c.Drain)
+	if job.Drain.Adaptive() {
+		job.Drain.MinBatchSize = cmp.Or(job.Drain.MinBatchSize, 1)
+		job.Drain.TargetLatency = cmp.Or(job.Drain.TargetLatency, DefaultTargetLatency)
+	}
+
+	job.DB.Cursor.Column = cmp.Or(job.DB.Cursor.Column, c.DB.Cursor.Column)
+	job.DB.Cursor.Type = cmp.Or(job.DB.Cursor.Type, c.DB.Cursor.Type)
+	job.DB.Cursor.Default = cmp.Or(job.DB.Cursor.Default, c.DB.Cursor.Default)
+	job.DB.Cursor.TimeFormat = cmp.Or(job.DB.Cursor.TimeFormat, c.DB.Cursor.TimeFormat)
+	job.DB.Cursor.Lookback = cmp.Or(job.DB.Cursor.Lookback, c.DB.Cursor.Lookback)
+
+	job.Redis.Mode = cmp.Or(job.Redis.Mode, c.Redis.Mode)
+	job.Redis.ValueFormat = cmp.Or(job.Redis.ValueFormat, c.Redis.ValueFormat)
+	job.Redis.KeyNull.Policy = cmp.Or(job.Redis.KeyNull.Policy, c.Redis.KeyNull.Policy)
+	job.Redis.ValueNull.Policy = cmp.Or(job.Redis.ValueNull.Policy, c.Redis.ValueNull.Policy)
+	job.Redis.DeleteCommand = cmp.Or(job.Redis.DeleteCommand, c.Redis.DeleteCommand)
+	job.Redis.VersionKeySuffix = cmp.Or(job.Redis.VersionKeySuffix, c.Redis.VersionKeySuffix)
+	job.Redis.Generation.DeleteDelay = cmp.Or(job.Redis.Generation.DeleteDelay, c.Redis.Generation.DeleteDelay)
+
+	switch {
+	case job.DB.SelectQuery == "":
+		return errors.New("select query should be defined")
+	case job.Redis.Key == "":
+		return errors.New("redis key should be defined")
+	case job.Redis.Mode != ModeString && job.Redis.Mode != ModeHash:
+		return fmt.Errorf("unsupported redis mode: %s", job.Redis.Mode)
+	case job.Redis.Mode == ModeString && job.Redis.ValueFormat == ValueFormatTemplate && job.Redis.Value == "":
+		return errors.New("redis value should be defined")
+	case job.Redis.CursorKey == "":
+		return errors.New("redis cursor key should be defined")
+	case job.Redis.DeleteCommand != DeleteCommandDel && job.Redis.DeleteCommand != DeleteCommandUnlink:
+		return fmt.Errorf("unsupported delete command: %s", job.Redis.DeleteCommand)
+	case job.Redis.VersionColumn != "" && job.Redis.VersionKeySuffix == "":
+		return errors.New("version key suffix should be defined when using a version column")
+	}
+
+	cursorInfo, err := job.DB.Cursor.Info()
+	if err != nil {
+		return fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err := job.DB.Cursor.Lookback.Validate(cursorInfo, job.BatchSize); err != nil {
+		return fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err := job.DB.ValidateColumnTypes(); err != nil {
+		return err
+	}
+	if err := job.Redis.Generation.Validate(); err != nil {
+		return err
+	}
+	if err := job.Redis.KeyNull.Validate(false); err != nil {
+		return fmt.Errorf("invalid key null policy: %w", err)
+	}
+	if err := job.Redis.ValueNull.Validate(true); err != nil {
+		return fmt.Errorf("invalid value null policy: %w", err)
+	}
+	if _, err := job.Redis.DeleteWhen.DeleteFn(zap.NewNop()); err != nil {
+		return err
+	}
+
+	if err := validateBackfill(c, dialect, job); err != nil {
+		return err
+	}
+
+	if err := job.BindSelectQuery(dialect); err != nil {
+		return err
+	}
+
+	return validateDrain(job)
+}
@@ -0,0 +1,365 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load()", func() {
+	writeConfig := func(content string) string {
+		filename := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(filename, []byte(content), 0o600)).To(Succeed())
+		return filename
+	}
+
+	It("should build a default job from the db and redis sections", func() {
+		filename := writeConfig(`
+batchSize: 10
+db:
+  selectQuery: SELECT id, name FROM users WHERE id > $1
+redis:
+  key: users:${id}
+  value: ${name}
+  cursorKey: users:latest
+`)
+		c, fileExists, err := Load(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileExists).To(BeTrue())
+		Expect(c.Jobs).To(HaveLen(1))
+
+		job := c.Jobs[0]
+		Expect(job.Name).To(Equal("default"))
+		Expect(job.DB.SelectQuery).To(Equal("SELECT id, name FROM users WHERE id > $1 LIMIT 10"))
+		Expect(job.DB.Cursor).To(Equal(CursorConfig{Column: "id", Type: "int64", Default: "-1"}))
+		Expect(job.Redis.Key).To(Equal("users:${id}"))
+		Expect(job.Redis.CursorKey).To(Equal("users:latest"))
+		Expect(job.PollDelay).To(Equal(2 * time.Second))
+	})
+
+	It("should load the jobs and inherit the top-level settings", func() {
+		filename := writeConfig(`
+pollDelay: 5s
+jobs:
+  - name: users
+    batchSize: 50
+    db:
+      selectQuery: SELECT id, name FROM users WHERE id > $1
+    redis:
+      key: users:${id}
+      value: ${name}
+      cursorKey: users:latest
+  - name: orders
+    pollDelay: 1s
+    db:
+      selectQuery: SELECT id, total FROM orders WHERE id > $1
+      cursor:
+        type: int
+        default: "0"
+    redis:
+      key: orders:${id}
+      value: ${total}
+      cursorKey: orders:latest
+`)
+		c, _, err := Load(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Jobs).To(HaveLen(2))
+
+		Expect(c.Jobs[0].Name).To(Equal("users"))
+		Expect(c.Jobs[0].PollDelay).To(Equal(5 * time.Second))
+		Expect(c.Jobs[0].BatchSize).To(Equal(50))
+		Expect(c.Jobs[0].DB.SelectQuery).To(HaveSuffix(" LIMIT 50"))
+
+		Expect(c.Jobs[1].Name).To(Equal("orders"))
+		Expect(c.Jobs[1].PollDelay).To(Equal(time.Second))
+		Expect(c.Jobs[1].BatchSize).To(Equal(200))
+		Expect(c.Jobs[1].DB.Cursor).To(Equal(CursorConfig{Column: "id", Type: "int", Default: "0"}))
+	})
+
+	It("should fail when jobs share the cursor key", func() {
+		filename := writeConfig(`
+jobs:
+  - name: a
+    db:
+      selectQuery: SELECT id FROM a WHERE id > $1
+    redis:
+      key: a:${id}
+      value: ${id}
+      cursorKey: latest
+  - name: b
+    db:
+      selectQuery: SELECT id FROM b WHERE id > $1
+    redis:
+      key: b:${id}
+      value: ${id}
+      cursorKey: latest
+`)
+		_, _, err := Load(filename)
+		Expect(err).To(MatchError(ContainSubstring("cursor key already used")))
+	})
+
+	It("should inherit the backfill settings", func() {
+		filename := writeConfig(`
+db:
+  backfill:
+    rowsPerSecond: 500
+jobs:
+  - name: users
+    batchSize: 50
+    db:
+      selectQuery: SELECT id, name FROM users WHERE id > $1
+    redis:
+      key: users:${id}
+      value: ${name}
+      cursorKey: users:latest
+  - name: orders
+    db:
+      selectQuery: SELECT id, total FROM orders WHERE updated_at > :cursor LIMIT :batch_size
+      cursor:
+        column: updated_at
+        type: timestamp
+        default: "2024-01-01T00:00:00Z"
+      backfill:
+        selectQuery: SELECT id, total FROM orders WHERE id > :cursor ORDER BY id LIMIT :batch_size
+        batchSize: 1000
+        checkpointKey: orders:backfill-progress
+    redis:
+      key: orders:${id}
+      value: ${total}
+      cursorKey: orders:latest
+`)
+		c, _, err := Load(filename)
+		Expect(err).NotTo(HaveOccurred())
+
+		users := c.Jobs[0].BackfillJob()
+		Expect(users.Name).To(Equal("users/backfill"))
+		Expect(users.DB.SelectQuery).To(Equal("SELECT id, name FROM users WHERE id > $1 LIMIT 50"))
+		Expect(users.DB.Cursor).To(Equal(CursorConfig{Column: "id", Type: "int64", Default: "-1"}))
+		Expect(users.DB.Backfill.RowsPerSecond).To(Equal(500))
+		Expect(users.Redis.CursorKey).To(Equal("users:latest:backfill"))
+		Expect(users.LiveCursorKey()).To(Equal("users:latest"))
+
+		orders := c.Jobs[1].BackfillJob()
+		Expect(orders.BatchSize).To(Equal(1000))
+		Expect(orders.DB.SelectQuery).To(Equal("SELECT id, total FROM orders WHERE id > $1 ORDER BY id LIMIT $2"))
+		Expect(orders.DB.Cursor).To(Equal(CursorConfig{Column: "id", Type: "int64", Default: "-1"}))
+		Expect(orders.QueryArgs(int64(10))).To(Equal([]any{int64(10), 1000}))
+		Expect(orders.Redis.CursorKey).To(Equal("orders:backfill-progress"))
+		Expect(orders.ReservedKeys()).To(ContainElements("orders:backfill-progress", "orders:latest"))
+		Expect(orders.LiveCursorKey()).To(BeEmpty())
+	})
+
+	It("should fail when the backfill checkpoint key is the cursor key", func() {
+		filename := writeConfig(`
+db:
+  selectQuery: SELECT id FROM a WHERE id > $1
+  backfill:
+    checkpointKey: a:latest
+redis:
+  key: a:${id}
+  cursorKey: a:latest
+`)
+		_, _, err := Load(filename)
+		Expect(err).To(MatchError(ContainSubstring("checkpoint key should be different")))
+	})
+
+	It("should fail when the generation is not valid", func() {
+		for _, generation := range []string{
+			"{name: v2}",
+			"{pointerKey: users:generation}",
+			"{name: 'v2:*', pointerKey: users:generation}",
+		} {
+			filename := writeConfig(`
+db:
+  selectQuery: SELECT id FROM users WHERE id > $1
+redis:
+  key: users:${id}
+  generation: ` + generation)
+			_, _, err := Load(filename)
+			Expect(err).To(MatchError(ContainSubstring("generation")), "generation %s", generation)
+		}
+	})
+
+	It("should fail when jobs share the generation", func() {
+		filename := writeConfig(`
+jobs:
+  - name: a
+    db:
+      selectQuery: SELECT id FROM a WHERE id > $1
+    redis:
+      key: a:${id}
+      value: ${id}
+      cursorKey: a:latest
+      generation: {name: v2, pointerKey: a:generation}
+  - name: b
+    db:
+      selectQuery: SELECT id FROM b WHERE id > $1
+    redis:
+      key: b:${id}
+      value: ${id}
+      cursorKey: b:latest
+      generation: {name: v2, pointerKey: b:generation}
+`)
+		_, _, err := Load(filename)
+		Expect(err).To(MatchError(ContainSubstring("generation already used")))
+	})
+
+	It("should inherit the drain settings", func() {
+		filename := writeConfig(`
+drain:
+  enabled: true
+  maxBatchSize: 1000
+jobs:
+  - name: users
+    db:
+      selectQuery: SELECT id, name FROM users WHERE id > :cursor ORDER BY id LIMIT :batch_size
+    redis:
+      key: users:${id}
+      value: ${name}
+      cursorKey: users:latest
+  - name: orders
+    drain:
+      maxRowsPerSecond: 100
+    db:
+      selectQuery: SELECT id, total FROM orders WHERE id > $1
+    redis:
+      key: orders:${id}
+      value: ${total}
+      cursorKey: orders:latest
+`)
+		c, _, err := Load(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Jobs[0].Drain).To(Equal(DrainConfig{
+			Enabled:       true,
+			MinBatchSize:  1,
+			MaxBatchSize:  1000,
+			TargetLatency: DefaultTargetLatency,
+		}))
+		Expect(c.Jobs[1].Drain).To(Equal(DrainConfig{MaxRowsPerSecond: 100}))
+	})
+
+	It("should fail when the adaptive batch size is used without :batch_size", func() {
+		filename := writeConfig(`
+drain:
+  maxBatchSize: 1000
+db:
+  selectQuery: SELECT id FROM users WHERE id > $1
+redis:
+  key: users:${id}
+`)
+		_, _, err := Load(filename)
+		Expect(err).To(MatchError(ContainSubstring(":batch_size")))
+	})
+
+	It("should fail when the marker key is used as a cursor key", func() {
+		filename := writeConfig(`
+jobs:
+  - name: a
+    db:
+      selectQuery: SELECT id FROM a WHERE id > $1
+    redis:
+      key: a:${id}
+      value: ${id}
+      cursorKey: a:latest
+  - name: b
+    db:
+      selectQuery: SELECT id FROM b WHERE id > $1
+    redis:
+      key: b:${id}
+      value: ${id}
+      cursorKey: b:latest
+      markerKey: a:latest
+`)
+		_, _, err := Load(filename)
+		Expect(err).To(MatchError(ContainSubstring("marker key already used")))
+	})
+
+	It("should fail when the key null policy is delete", func() {
+		filename := writeConfig(`
+redis:
+  keyNull:
+    policy: delete
+`)
+		_, _, err := Load(filename)
+		Expect(err).To(MatchError(ContainSubstring("invalid key null policy")))
+	})
+
+	It("should load the redis targets", func() {
+		filename := writeConfig(`
+redis:
+  targets:
+    - name: eu
+      host: redis-eu
+    - name: us
+      addrs: [redis-us-1:7000, redis-us-2:7000]
+`)
+		c, _, err := Load(filename)
+		Expect(err).NotTo(HaveOccurred())
+
+		targets := c.Redis.AllTargets()
+		Expect(targets).To(HaveLen(2))
+		Expect(targets[0].Name).To(Equal("eu"))
+		opts, err := targets[0].UniversalOptions()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts.Addrs).To(Equal([]string{"redis-eu:6379"}))
+		Expect(targets[1].Addrs).To(Equal([]string{"redis-us-1:7000", "redis-us-2:7000"}))
+	})
+
+	It("should fail when a transactional job writes to a redis cluster or ring", func() {
+		for _, connection := range []string{"addrs: [redis-1:7000, redis-2:7000]", "ring: {a: redis-a:6379}"} {
+			filename := writeConfig(`
+redis:
+  transactional: true
+  ` + connection)
+			_, _, err := Load(filename)
+			Expect(err).To(MatchError(ContainSubstring("transactional batches are not supported")), connection)
+		}
+	})
+
+	It("should fail when a versioned job writes to a redis cluster without a hash tag", func() {
+		content := `
+redis:
+  cluster: true
+  versionColumn: updated_at
+  key: `
+		_, _, err := Load(writeConfig(content + "users:${id}"))
+		Expect(err).To(MatchError(ContainSubstring("requires a hash tag")))
+
+		_, _, err = Load(writeConfig(content + "users:{${id}}"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should use the top-level connection when no targets are defined", func() {
+		filename := writeConfig(`
+redis:
+  host: redis-main
+`)
+		c, _, err := Load(filename)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Redis.AllTargets()).To(Equal([]RedisTargetConfig{{RedisConnectionConfig: c.Redis.RedisConnectionConfig}}))
+	})
+
+	It("should fail when redis targets share the name", func() {
+		filename := writeConfig(`
+redis:
+  targets:
+    - name: eu
+    - name: eu
+`)
+		_, _, err := Load(filename)
+		Expect(err).To(MatchError(ContainSubstring("duplicated redis target name")))
+	})
+
+	It("should fail when a job has no name", func() {
+		filename := writeConfig(`
+jobs:
+  - db:
+      selectQuery: SELECT id FROM a WHERE id > $1
+`)
+		_, _, err := Load(filename)
+		Expect(err).To(MatchError(ContainSubstring("should have a name")))
+	})
+})
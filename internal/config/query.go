@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+var (
+	limitRegex       = regexp.MustCompile(`(?i)\b(LIMIT\s+\d+|TOP\s*\(?\s*\d+|FETCH\s+(FIRST|NEXT)\b)`)
+	cursorParamRegex = regexp.MustCompile(`^cursor_(\d+)$`)
+)
+
+// Names of the placeholders bound by the worker.
+const (
+	ParamCursor    = "cursor"
+	ParamBatchSize = "batch_size"
+	// ParamSnapshotXmin is the xmin of the current snapshot, bound to :snapshot_xmin or $2 with snapshot cursors.
+	ParamSnapshotXmin = "snapshot_xmin"
+)
+
+// BindSelectQuery prepares the select query for the dialect. Queries with named placeholders (e.g. :cursor,
+// :batch_size or the configured params) are used as is, otherwise the legacy $1 placeholder is bound to the cursor
+// and the row limit is appended to the query. The values of a compound cursor are bound to :cursor_1, :cursor_2, ...
+// or $1, $2, ... in the legacy mode.
+func (c *JobConfig) BindSelectQuery(d Dialect) error {
+	snapshot := slices.Contains(splitList(c.DB.Cursor.Type), CursorTypeSnapshot)
+	if _, ok := d.(postgresDialect); snapshot && !ok {
+		return errors.New("snapshot cursor is only supported with postgres")
+	}
+
+	query, names, err := BindNamed(d, c.DB.SelectQuery)
+	if err != nil {
+		return fmt.Errorf("invalid select query: %w", err)
+	}
+
+	if names != nil {
+		cursorColumns := len(splitList(c.DB.Cursor.Column))
+		for _, name := range names {
+			if match := cursorParamRegex.FindStringSubmatch(name); match != nil {
+				if index, _ := strconv.Atoi(match[1]); index < 1 || index > cursorColumns {
+					return fmt.Errorf("select query parameter '%s' doesn't match a cursor column", name)
+				}
+				continue
+			}
+			if name == ParamCursor && cursorColumns > 1 {
+				return errors.New("select query with a compound cursor should use :cursor_1, :cursor_2, ... parameters")
+			}
+			if name == ParamSnapshotXmin && snapshot {
+				continue
+			}
+			if _, ok := c.DB.Params[name]; !ok && name != ParamCursor && name != ParamBatchSize {
+				return fmt.Errorf("select query parameter '%s' is not defined", name)
+			}
+		}
+		if !slices.Contains(names, ParamBatchSize) {
+			return errors.New("select query with named placeholders should limit the rows using :batch_size")
+		}
+		if snapshot && !slices.Contains(names, ParamSnapshotXmin) {
+			return errors.New("select query with a snapshot cursor should only read the rows below :snapshot_xmin")
+		}
+
+		c.DB.SelectQuery = query
+		c.DB.queryParams = names
+		return nil
+	}
+
+	if limitRegex.MatchString(c.DB.SelectQuery) {
+		return errors.New("select query should not limit the rows (LIMIT, TOP or FETCH), use :batch_size instead")
+	}
+
+	if query, err = Rebind(d, c.DB.SelectQuery); err != nil {
+		return fmt.Errorf("invalid select query: %w", err)
+	}
+	c.DB.SelectQuery = d.Limit(query, c.BatchSize)
+	return nil
+}
+
+// QueryArgs returns the arguments of the select query for the cursor value.
+func (c *JobConfig) QueryArgs(cursor any) []any {
+	return c.QueryArgsWithBatchSize(cursor, c.BatchSize)
+}
+
+// QueryArgsWithBatchSize returns the arguments of the select query for the cursor value, binding :batch_size to the
+// batch size instead of the configured one, e.g. with the adaptive batch size.
+func (c *JobConfig) QueryArgsWithBatchSize(cursor any, batchSize int) []any {
+	var snapshotXmin any
+	if snapshot, ok := cursor.(SnapshotCursor); ok {
+		cursor, snapshotXmin = snapshot.Cursor, snapshot.Xmin
+	}
+	tuple, ok := cursor.(CursorTuple)
+	if !ok {
+		tuple = CursorTuple{cursor}
+	}
+	if c.DB.queryParams == nil {
+		if snapshotXmin != nil {
+			return append(tuple, snapshotXmin)
+		}
+		return tuple
+	}
+
+	args := make([]any, 0, len(c.DB.queryParams))
+	for _, name := range c.DB.queryParams {
+		switch name {
+		case ParamCursor:
+			args = append(args, cursor)
+		case ParamBatchSize:
+			args = append(args, batchSize)
+		default:
+			if name == ParamSnapshotXmin && snapshotXmin != nil {
+				args = append(args, snapshotXmin)
+				continue
+			}
+			if match := cursorParamRegex.FindStringSubmatch(name); match != nil {
+				index, _ := strconv.Atoi(match[1])
+				args = append(args, tuple[index-1])
+				continue
+			}
+			args = append(args, c.DB.Params[name])
+		}
+	}
+	return args
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JobConfig", func() {
+	Describe("BindSelectQuery()", func() {
+		It("should bind the named placeholders", func() {
+			c := JobConfig{
+				DB: JobDBConfig{
+					SelectQuery: `WITH recent AS (SELECT id, name::text FROM users WHERE id > :cursor AND tenant = :tenant
+						LIMIT :batch_size) SELECT * FROM recent FOR UPDATE -- :ignored`,
+					Params: map[string]string{"tenant": "acme"},
+				},
+				BatchSize: 10,
+			}
+			Expect(c.BindSelectQuery(postgresDialect{})).To(Succeed())
+			Expect(c.DB.SelectQuery).To(Equal(`WITH recent AS (SELECT id, name::text FROM users WHERE id > $1 AND tenant = $2
+						LIMIT $3) SELECT * FROM recent FOR UPDATE -- :ignored`))
+			Expect(c.QueryArgs(int64(5))).To(Equal([]any{int64(5), "acme", 10}))
+			Expect(c.QueryArgsWithBatchSize(int64(5), 20)).To(Equal([]any{int64(5), "acme", 20}))
+		})
+
+		It("should repeat the arguments with positional placeholders", func() {
+			c := JobConfig{
+				DB:        JobDBConfig{SelectQuery: "SELECT id FROM a WHERE id > :cursor OR id = :cursor LIMIT :batch_size"},
+				BatchSize: 10,
+			}
+			Expect(c.BindSelectQuery(mysqlDialect{})).To(Succeed())
+			Expect(c.DB.SelectQuery).To(Equal("SELECT id FROM a WHERE id > ? OR id = ? LIMIT ?"))
+			Expect(c.QueryArgs("1")).To(Equal([]any{"1", "1", 10}))
+		})
+
+		It("should use the legacy placeholder and append the limit", func() {
+			c := JobConfig{DB: JobDBConfig{SelectQuery: "SELECT id FROM a WHERE id > $1"}, BatchSize: 10}
+			Expect(c.BindSelectQuery(sqlServerDialect{})).To(Succeed())
+			Expect(c.DB.SelectQuery).To(Equal("SELECT TOP (10) id FROM a WHERE id > @p1"))
+			Expect(c.QueryArgs("1")).To(Equal([]any{"1"}))
+		})
+
+		It("should bind the values of a compound cursor", func() {
+			c := JobConfig{
+				DB: JobDBConfig{
+					SelectQuery: "SELECT id FROM a WHERE (updated_at, id) > (:cursor_1, :cursor_2) LIMIT :batch_size",
+					Cursor:      CursorConfig{Column: "updated_at,id"},
+				},
+				BatchSize: 10,
+			}
+			Expect(c.BindSelectQuery(mysqlDialect{})).To(Succeed())
+			Expect(c.DB.SelectQuery).To(Equal("SELECT id FROM a WHERE (updated_at, id) > (?, ?) LIMIT ?"))
+			Expect(c.QueryArgs(CursorTuple{"2024", int64(1)})).To(Equal([]any{"2024", int64(1), 10}))
+
+			c.DB.SelectQuery = "SELECT id FROM a WHERE (updated_at, id) > ($1, $2)"
+			c.DB.queryParams = nil
+			Expect(c.BindSelectQuery(postgresDialect{})).To(Succeed())
+			Expect(c.QueryArgs(CursorTuple{"2024", int64(1)})).To(Equal([]any{"2024", int64(1)}))
+		})
+
+		It("should bind the snapshot xmin", func() {
+			c := JobConfig{
+				DB: JobDBConfig{
+					SelectQuery: "SELECT id FROM a WHERE txid > :cursor AND txid < :snapshot_xmin ORDER BY txid " +
+						"LIMIT :batch_size",
+					Cursor: CursorConfig{Column: "txid", Type: "snapshot"},
+				},
+				BatchSize: 10,
+			}
+			Expect(c.BindSelectQuery(postgresDialect{})).To(Succeed())
+			Expect(c.DB.SelectQuery).To(Equal("SELECT id FROM a WHERE txid > $1 AND txid < $2 ORDER BY txid LIMIT $3"))
+			Expect(c.QueryArgs(SnapshotCursor{Cursor: int64(5), Xmin: 20})).To(Equal([]any{int64(5), int64(20), 10}))
+
+			c.DB.SelectQuery = "SELECT id FROM a WHERE txid > $1 AND txid < $2 ORDER BY txid"
+			c.DB.queryParams = nil
+			Expect(c.BindSelectQuery(postgresDialect{})).To(Succeed())
+			Expect(c.QueryArgs(SnapshotCursor{Cursor: int64(5), Xmin: 20})).To(Equal([]any{int64(5), int64(20)}))
+		})
+
+		It("should fail when the snapshot cursor is not supported", func() {
+			c := JobConfig{
+				DB: JobDBConfig{
+					SelectQuery: "SELECT id FROM a WHERE txid > $1 AND txid < $2 ORDER BY txid",
+					Cursor:      CursorConfig{Column: "txid", Type: "snapshot"},
+				},
+				BatchSize: 10,
+			}
+			Expect(c.BindSelectQuery(mysqlDialect{})).NotTo(Succeed())
+
+			c.DB.SelectQuery = "SELECT id FROM a WHERE txid > :cursor ORDER BY txid LIMIT :batch_size"
+			Expect(c.BindSelectQuery(postgresDialect{})).NotTo(Succeed())
+		})
+
+		It("should fail when the query is not valid", func() {
+			for _, query := range []string{
+				"SELECT id FROM a WHERE id > $1 LIMIT 10",
+				"SELECT id FROM a WHERE id > :cursor",
+				"SELECT id FROM a WHERE id > :cursor AND b = :unknown LIMIT :batch_size",
+				"SELECT id FROM a WHERE id > :cursor LIMIT :batch_size",
+				"SELECT id FROM a WHERE (b, id) > (:cursor_1, :cursor_3) LIMIT :batch_size",
+			} {
+				c := JobConfig{DB: JobDBConfig{SelectQuery: query, Cursor: CursorConfig{Column: "b,id"}}, BatchSize: 10}
+				Expect(c.BindSelectQuery(postgresDialect{})).NotTo(Succeed(), "query %s", query)
+			}
+		})
+	})
+})
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ValidateRing checks that the ring settings are consistent with the rest of the connection settings.
+func (c *RedisConnectionConfig) ValidateRing() error {
+	if len(c.Ring) == 0 {
+		if c.CursorShard != "" {
+			return errors.New("cursor shard should only be defined with a ring")
+		}
+		return nil
+	}
+
+	if c.URL != "" || c.Cluster || c.MasterName != "" {
+		return errors.New("ring is not supported with a url, cluster or sentinel")
+	}
+	if _, err := c.cursorShard(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NewCursorClient returns the client of the ring shard that stores the cursor, or nil when the ring is not used.
+func (c *RedisConnectionConfig) NewCursorClient() (*redis.Client, error) {
+	if len(c.Ring) == 0 {
+		return nil, nil
+	}
+
+	shard, err := c.cursorShard()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := c.UniversalOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	simple := opts.Simple()
+	simple.Addr = c.Ring[shard]
+	return redis.NewClient(simple), nil
+}
+
+func (c *RedisConnectionConfig) cursorShard() (string, error) {
+	if c.CursorShard == "" {
+		return slices.Min(slices.Collect(maps.Keys(c.Ring))), nil
+	}
+
+	if _, ok := c.Ring[c.CursorShard]; !ok {
+		return "", fmt.Errorf("cursor shard '%s' not found in ring", c.CursorShard)
+	}
+	return c.CursorShard, nil
+}
+
+func (c *RedisConnectionConfig) ringOptions(opts *redis.UniversalOptions) *redis.RingOptions {
+	return &redis.RingOptions{
+		Addrs:     c.Ring,
+		Username:  opts.Username,
+		Password:  opts.Password,
+		TLSConfig: opts.TLSConfig,
+	}
+}
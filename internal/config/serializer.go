@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+)
+
+// serializedValueFn returns a function that serializes the row columns defined by Fields (or all the columns) into a
+// json or msgpack document.
+func (c *JobRedisConfig) serializedValueFn(logger *zap.Logger) (ValueFunc, error) {
+	columnsFn, err := c.columnsFn(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	marshal := marshalJSON
+	if c.ValueFormat == ValueFormatMsgpack {
+		marshal = marshalMsgpack
+	}
+
+	logger.Info("Using serialized redis value", zap.String("format", c.ValueFormat))
+
+	return func(row map[string]any) (any, error) {
+		fields, err := columnsFn(row)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("unable to serialize value as %s: %w", c.ValueFormat, err)
+		}
+		return value, nil
+	}, nil
+}
+
+func marshalJSON(fields map[string]any) ([]byte, error) {
+	return json.Marshal(fields)
+}
+
+func marshalMsgpack(fields map[string]any) ([]byte, error) {
+	document, err := msgpackValue(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	// Sort the keys to produce the same document for the same row
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(document); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// msgpackValue converts the json and numeric column values to msgpack types, otherwise they are encoded as binary and
+// strings: json documents are decoded into maps and arrays and numbers into integers or floats.
+func msgpackValue(value any) (any, error) {
+	switch v := value.(type) {
+	case json.RawMessage:
+		dec := json.NewDecoder(bytes.NewReader(v))
+		dec.UseNumber()
+		var document any
+		if err := dec.Decode(&document); err != nil {
+			return nil, fmt.Errorf("unable to decode json value: %w", err)
+		}
+		return msgpackValue(document)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return u, nil
+		}
+		if f, err := v.Float64(); err == nil {
+			return f, nil
+		}
+		// Out of the range of a float
+		return v.String(), nil
+	case map[string]any:
+		converted := make(map[string]any, len(v))
+		for name, item := range v {
+			var err error
+			if converted[name], err = msgpackValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	case []any:
+		converted := make([]any, len(v))
+		for i, item := range v {
+			var err error
+			if converted[i], err = msgpackValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("JobRedisConfig", func() {
+	logger, err := zap.NewDevelopment()
+	Expect(err).NotTo(HaveOccurred())
+	row := map[string]any{
+		"id":         int64(1),
+		"name":       `quoted "name"`,
+		"created_at": time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC),
+		"deleted_at": nil,
+	}
+
+	Describe("ValueFn() with serialized format", func() {
+		It("should serialize all the columns as json", func() {
+			c := JobRedisConfig{ValueFormat: ValueFormatJSON}
+			fn, err := c.ValueFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			value, err := fn(row)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(MatchJSON(
+				`{"id":1,"name":"quoted \"name\"","created_at":"2024-10-01T12:00:00Z","deleted_at":null}`))
+		})
+
+		It("should serialize the selected columns as json", func() {
+			c := JobRedisConfig{ValueFormat: ValueFormatJSON, Fields: map[string]string{"id": "userId", "name": "name"}}
+			fn, err := c.ValueFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			value, err := fn(row)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(MatchJSON(`{"userId":1,"name":"quoted \"name\""}`))
+		})
+
+		It("should serialize the selected columns as msgpack", func() {
+			c := JobRedisConfig{ValueFormat: ValueFormatMsgpack, Fields: map[string]string{"id": "id", "created_at": "ts"}}
+			fn, err := c.ValueFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			value, err := fn(row)
+			Expect(err).NotTo(HaveOccurred())
+
+			var decoded map[string]any
+			Expect(msgpack.Unmarshal(value.([]byte), &decoded)).To(Succeed())
+			Expect(decoded).To(HaveKeyWithValue("id", BeEquivalentTo(1)))
+			Expect(decoded).To(HaveKey("ts"))
+			Expect(decoded["ts"].(time.Time).Equal(row["created_at"].(time.Time))).To(BeTrue())
+		})
+
+		It("should serialize the json and numeric columns as msgpack types", func() {
+			c := JobRedisConfig{ValueFormat: ValueFormatMsgpack}
+			fn, err := c.ValueFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			value, err := fn(map[string]any{
+				"doc":    json.RawMessage(`{"items":[1,2.5,"x"],"nested":{"enabled":true}}`),
+				"amount": json.Number("12.34"),
+				"count":  json.Number("42"),
+				"tags":   []any{"a", json.Number("3")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var decoded map[string]any
+			Expect(msgpack.Unmarshal(value.([]byte), &decoded)).To(Succeed())
+			Expect(decoded["amount"]).To(Equal(12.34))
+			Expect(decoded["count"]).To(BeEquivalentTo(42))
+			Expect(decoded["tags"]).To(HaveExactElements("a", BeEquivalentTo(3)))
+			Expect(decoded["doc"]).To(BeAssignableToTypeOf(map[string]any{}))
+			doc := decoded["doc"].(map[string]any)
+			Expect(doc["items"]).To(HaveExactElements(BeEquivalentTo(1), Equal(2.5), Equal("x")))
+			Expect(doc["nested"]).To(Equal(map[string]any{"enabled": true}))
+		})
+
+		It("should fail with an unsupported format", func() {
+			c := JobRedisConfig{ValueFormat: "xml"}
+			_, err := c.ValueFn(logger)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,358 @@
+package config
+
+import (
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// template is a parsed key or value template, made of literal text and placeholders in the form
+// ${column1,column2|func1|func2:arg}. A literal "${" can be expressed as "$${".
+type template struct {
+	text       string
+	segments   []templateSegment
+	nullPolicy NullPolicyConfig
+}
+
+// templateSegment is either literal text or a placeholder referencing one or more columns.
+type templateSegment struct {
+	literal string
+	columns []string
+	funcs   []templateFunc
+}
+
+type templateFunc func(value any) (any, error)
+
+// templateFuncs contains the functions that can be used in the placeholders, each one receiving the text after
+// the colon as argument.
+var templateFuncs = map[string]func(arg string) (templateFunc, error){
+	"lower":   noArgs(stringFunc(strings.ToLower)),
+	"upper":   noArgs(stringFunc(strings.ToUpper)),
+	"trim":    noArgs(stringFunc(strings.TrimSpace)),
+	"md5":     noArgs(hashFunc(md5.New)),
+	"sha1":    noArgs(hashFunc(sha1.New)),
+	"sha256":  noArgs(hashFunc(sha256.New)),
+	"pad":     padFunc,
+	"date":    dateFunc,
+	"default": defaultFunc,
+}
+
+// multiColumnSeparator is used to join the values of a placeholder that references multiple columns.
+const multiColumnSeparator = ":"
+
+func parseTemplate(text string) (*template, error) {
+	t := &template{text: text}
+	literal := strings.Builder{}
+	hasPlaceholders := false
+
+	for i := 0; i < len(text); {
+		if strings.HasPrefix(text[i:], "$${") {
+			literal.WriteString("${")
+			i += 3
+			continue
+		}
+
+		if !strings.HasPrefix(text[i:], "${") {
+			literal.WriteByte(text[i])
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(text[i:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated placeholder in template: %s", text)
+		}
+
+		segment, err := parsePlaceholder(text[i+2 : i+end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid placeholder in template %s: %w", text, err)
+		}
+
+		if literal.Len() > 0 {
+			t.segments = append(t.segments, templateSegment{literal: literal.String()})
+			literal.Reset()
+		}
+		t.segments = append(t.segments, *segment)
+		hasPlaceholders = true
+		i += end + 1
+	}
+
+	if !hasPlaceholders {
+		return nil, fmt.Errorf("no parameters found in key/value: %s", text)
+	}
+
+	if literal.Len() > 0 {
+		t.segments = append(t.segments, templateSegment{literal: literal.String()})
+	}
+
+	return t, nil
+}
+
+func parsePlaceholder(text string) (*templateSegment, error) {
+	parts := strings.Split(text, "|")
+	segment := &templateSegment{}
+	for _, column := range strings.Split(parts[0], ",") {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			return nil, fmt.Errorf("empty column name in '%s'", text)
+		}
+		segment.columns = append(segment.columns, column)
+	}
+
+	for _, part := range parts[1:] {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), ":")
+		factory, ok := templateFuncs[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported function '%s'", name)
+		}
+
+		fn, err := factory(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid function '%s': %w", part, err)
+		}
+		segment.funcs = append(segment.funcs, fn)
+	}
+
+	return segment, nil
+}
+
+// globEscaper escapes the special characters of the redis glob-style patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// pattern returns the redis glob-style pattern matching the results of the template.
+func (t *template) pattern() string {
+	var b strings.Builder
+	for _, segment := range t.segments {
+		if segment.columns == nil {
+			b.WriteString(globEscaper.Replace(segment.literal))
+			continue
+		}
+		b.WriteString("*")
+	}
+	return b.String()
+}
+
+// MatchKeyPattern returns true when the key matches the redis glob-style pattern returned by the key templates, made of
+// the "*" and "?" wildcards and escaped literals.
+func MatchKeyPattern(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(key); i >= 0; i-- {
+				if MatchKeyPattern(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if key == "" {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+		if key == "" || key[0] != pattern[0] {
+			return false
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return key == ""
+}
+
+// hashTagged returns true when the results of the template contain a redis hash tag: a non-empty text between the
+// first "{" and the following "}", which determines the hash slot of the keys instead of the whole key.
+func (t *template) hashTagged() bool {
+	var b strings.Builder
+	for _, segment := range t.segments {
+		if segment.columns == nil {
+			b.WriteString(segment.literal)
+			continue
+		}
+		// The value of the placeholder is not known, it only matters that it's not empty
+		b.WriteString("_")
+	}
+
+	text := b.String()
+	start := strings.IndexByte(text, '{')
+	return start >= 0 && strings.IndexByte(text[start+1:], '}') > 0
+}
+
+// columns returns the names of the columns referenced by the template.
+func (t *template) columns() []string {
+	result := make([]string, 0, len(t.segments))
+	for _, segment := range t.segments {
+		result = append(result, segment.columns...)
+	}
+	return result
+}
+
+// single returns the placeholder when the template is made of a single placeholder without literal text.
+func (t *template) single() (*templateSegment, bool) {
+	if len(t.segments) != 1 {
+		return nil, false
+	}
+	return &t.segments[0], true
+}
+
+// execute returns the text of the template for the row.
+func (t *template) execute(row map[string]any) (string, error) {
+	b := strings.Builder{}
+	for i := range t.segments {
+		segment := &t.segments[i]
+		if segment.columns == nil {
+			b.WriteString(segment.literal)
+			continue
+		}
+
+		value, err := segment.eval(row)
+		if err != nil {
+			return "", err
+		}
+		if value == nil {
+			if value, err = t.nullValue(segment); err != nil {
+				return "", err
+			}
+		}
+		b.WriteString(fmt.Sprint(formatValue(value)))
+	}
+
+	return b.String(), nil
+}
+
+// nullValue returns the value used for a placeholder that evaluated to null, according to the null policy.
+func (t *template) nullValue(segment *templateSegment) (any, error) {
+	columns := strings.Join(segment.columns, ",")
+	switch t.nullPolicy.Policy {
+	case NullPolicySkip:
+		return nil, ErrSkipRow
+	case NullPolicyDelete:
+		return nil, ErrDeleteKey
+	case NullPolicyFail:
+		return nil, fmt.Errorf("%w: %s", ErrNullValue, columns)
+	case NullPolicyDefault:
+		return t.nullPolicy.Default, nil
+	}
+
+	// Ignore: format the null value
+	return nil, nil
+}
+
+// eval returns the value of the placeholder for the row, applying the functions in order. When any of the
+// columns is null, the value is null unless a function replaces it (e.g. default).
+func (s *templateSegment) eval(row map[string]any) (any, error) {
+	var value any
+	if len(s.columns) == 1 {
+		value = row[s.columns[0]]
+	} else {
+		values := make([]string, 0, len(s.columns))
+		for _, column := range s.columns {
+			if row[column] == nil {
+				values = nil
+				break
+			}
+			values = append(values, fmt.Sprint(formatValue(row[column])))
+		}
+		if values != nil {
+			value = strings.Join(values, multiColumnSeparator)
+		}
+	}
+
+	for _, fn := range s.funcs {
+		var err error
+		if value, err = fn(value); err != nil {
+			return nil, fmt.Errorf("unable to apply function on %s: %w", strings.Join(s.columns, ","), err)
+		}
+	}
+
+	return value, nil
+}
+
+func noArgs(fn templateFunc) func(arg string) (templateFunc, error) {
+	return func(arg string) (templateFunc, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("unexpected argument '%s'", arg)
+		}
+		return fn, nil
+	}
+}
+
+func stringFunc(fn func(string) string) templateFunc {
+	return func(value any) (any, error) {
+		if value == nil {
+			return nil, nil
+		}
+		return fn(fmt.Sprint(formatValue(value))), nil
+	}
+}
+
+func hashFunc(newHash func() hash.Hash) templateFunc {
+	return func(value any) (any, error) {
+		if value == nil {
+			return nil, nil
+		}
+		h := newHash()
+		h.Write([]byte(fmt.Sprint(formatValue(value))))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
+// padFunc returns a function that left pads the value with zeros to the provided width.
+func padFunc(arg string) (templateFunc, error) {
+	width, err := strconv.Atoi(arg)
+	if err != nil || width <= 0 {
+		return nil, fmt.Errorf("expected a positive width, obtained '%s'", arg)
+	}
+
+	return func(value any) (any, error) {
+		if value == nil {
+			return nil, nil
+		}
+		text := fmt.Sprint(formatValue(value))
+		if len(text) >= width {
+			return text, nil
+		}
+		return strings.Repeat("0", width-len(text)) + text, nil
+	}, nil
+}
+
+// dateFunc returns a function that formats time values using the provided layout.
+func dateFunc(layout string) (templateFunc, error) {
+	if layout == "" {
+		return nil, fmt.Errorf("expected a date layout")
+	}
+
+	return func(value any) (any, error) {
+		if value == nil {
+			return nil, nil
+		}
+		t, err := decodeTime(value)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := t.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("expected a time value, obtained %T", value)
+		}
+		return v.Format(layout), nil
+	}, nil
+}
+
+// defaultFunc returns a function that replaces null and empty values with the provided text.
+func defaultFunc(arg string) (templateFunc, error) {
+	return func(value any) (any, error) {
+		if value == nil || formatValue(value) == "" {
+			return arg, nil
+		}
+		return value, nil
+	}, nil
+}
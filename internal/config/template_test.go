@@ -0,0 +1,138 @@
+package config
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("template", func() {
+	row := map[string]any{
+		"id":         int64(1),
+		"email":      " John@Example.com ",
+		"a":          "x",
+		"b":          []byte("y"),
+		"created_at": time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC),
+		"empty":      nil,
+	}
+
+	tests := [][]string{
+		{"user:${email|trim|lower}", "user:john@example.com"},
+		{"user:${email|trim|upper}", "user:JOHN@EXAMPLE.COM"},
+		{"user:${id|pad:10}", "user:0000000001"},
+		{"day:${created_at|date:2006-01-02}", "day:2024-10-01"},
+		{"time:${created_at|date:15:04}", "time:12:30"},
+		{"ab:${a,b}", "ab:x:y"},
+		{"ab:${a, b|sha1}", "ab:30426a2acce6e508ec07f0c3620495af9a038dbe"},
+		{"value:${empty|default:none}", "value:none"},
+		{"value:${a|default:none}", "value:x"},
+		{"$${literal}:${id}", "${literal}:1"},
+		{"${id}$${", "1${"},
+	}
+
+	for _, test := range tests {
+		It("should execute "+test[0], func() {
+			t, err := parseTemplate(test[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.execute(row)).To(Equal(test[1]))
+		})
+	}
+
+	It("should fail to parse invalid templates", func() {
+		for _, text := range []string{
+			"no-placeholders",
+			"$${escaped}",
+			"${unterminated",
+			"${}",
+			"${a,}",
+			"${id|unknown}",
+			"${id|pad:0}",
+			"${id|pad:a}",
+			"${id|lower:a}",
+			"${created_at|date}",
+		} {
+			_, err := parseTemplate(text)
+			Expect(err).To(HaveOccurred(), "template %s", text)
+		}
+	})
+
+	It("should fail to execute when a function can not be applied", func() {
+		t, err := parseTemplate("${id|date:2006}")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = t.execute(row)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("null policy", func() {
+		tests := []struct {
+			policy   string
+			expected string
+			err      error
+		}{
+			{NullPolicyIgnore, "value:<nil>", nil},
+			{NullPolicyDefault, "value:none", nil},
+			{NullPolicySkip, "", ErrSkipRow},
+			{NullPolicyDelete, "", ErrDeleteKey},
+			{NullPolicyFail, "", ErrNullValue},
+		}
+
+		for _, test := range tests {
+			It("should apply the "+test.policy+" policy", func() {
+				t, err := parseTemplate("value:${a,empty|lower}")
+				Expect(err).NotTo(HaveOccurred())
+				t.nullPolicy = NullPolicyConfig{Policy: test.policy, Default: "none"}
+				result, err := t.execute(row)
+				if test.err != nil {
+					Expect(err).To(MatchError(test.err))
+					return
+				}
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(test.expected))
+			})
+		}
+
+		It("should not apply the policy when the default function is used", func() {
+			t, err := parseTemplate("value:${empty|default:other}")
+			Expect(err).NotTo(HaveOccurred())
+			t.nullPolicy = NullPolicyConfig{Policy: NullPolicyFail}
+			Expect(t.execute(row)).To(Equal("value:other"))
+		})
+
+		It("should apply the policy to the hash fields", func() {
+			c := JobRedisConfig{ValueNull: NullPolicyConfig{Policy: NullPolicyDefault, Default: "none"}}
+			fn, err := c.FieldsFn(zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(map[string]any{"a": "x", "empty": nil})).To(Equal(map[string]any{"a": "x", "empty": "none"}))
+
+			c.ValueNull.Policy = NullPolicySkip
+			fn, err = c.FieldsFn(zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			_, err = fn(map[string]any{"a": "x", "empty": nil})
+			Expect(err).To(MatchError(ErrSkipRow))
+		})
+	})
+})
+
+var _ = Describe("MatchKeyPattern()", func() {
+	It("should match the wildcards and the escaped literals", func() {
+		tests := []struct {
+			pattern string
+			key     string
+			matches bool
+		}{
+			{"users:*", "users:1", true},
+			{"users:*", "users:1:orders", true},
+			{"users:*:orders", "users:1:orders", true},
+			{"users:*:orders", "users:1", false},
+			{"users:?", "users:1", true},
+			{"users:?", "users:10", false},
+			{`users:\*:*`, "users:*:1", true},
+			{`users:\*:*`, "users:1:1", false},
+		}
+		for _, test := range tests {
+			Expect(MatchKeyPattern(test.pattern, test.key)).To(Equal(test.matches), "%s %s", test.pattern, test.key)
+		}
+	})
+})
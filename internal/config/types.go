@@ -3,38 +3,157 @@ package config
 import (
 	"cmp"
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
+	"maps"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
-var parameterRegex = regexp.MustCompile(`\$\{(.+?)\}`)
-
 type Config struct {
 	Redis     RedisConfig   `yaml:"redis" env-prefix:"WORKER_REDIS_"`
 	DB        DBConfig      `yaml:"db" env-prefix:"WORKER_DB_"`
 	PollDelay time.Duration `yaml:"pollDelay" env:"WORKER_POLL_DELAY" env-default:"2s"`
 	Debug     bool          `yaml:"debug" env:"WORKER_DEBUG" env-default:"false"`
 	BatchSize int           `yaml:"batchSize" env:"WORKER_BATCH_SIZE" env-default:"200"`
+
+	// MetricsAddress is the address to serve the job counters in expvar format (/debug/vars), e.g. ":9090".
+	// When empty, the metrics are not served.
+	MetricsAddress string `yaml:"metricsAddress" env:"WORKER_METRICS_ADDRESS"`
+
+	// CursorStore defines where the cursors of the jobs are stored, in redis by default.
+	CursorStore CursorStoreConfig `yaml:"cursorStore" env-prefix:"WORKER_CURSOR_STORE_"`
+
+	// Drain defines how the jobs catch up when the batches are full.
+	Drain DrainConfig `yaml:"drain" env-prefix:"WORKER_DRAIN_"`
+
+	// Jobs is the list of sync jobs that run in the worker process, sharing the db and redis connections. When no jobs
+	// are defined, a single job is built from the select query, cursor and templates in the db and redis sections.
+	Jobs []JobConfig `yaml:"jobs"`
+}
+
+// JobConfig describes a single sync job: the query used to read from the db and how the rows are written into redis.
+// Empty cursor, poll delay and batch size settings are inherited from the top-level config.
+type JobConfig struct {
+	Name      string         `yaml:"name"`
+	DB        JobDBConfig    `yaml:"db"`
+	Redis     JobRedisConfig `yaml:"redis"`
+	PollDelay time.Duration  `yaml:"pollDelay"`
+	BatchSize int            `yaml:"batchSize"`
+	Drain     DrainConfig    `yaml:"drain"`
+
+	// liveKeys are the cursor, marker and timestamp keys of the live job, set for the jobs derived from it (e.g. the
+	// backfill job) that don't write them.
+	liveKeys []string
+	// liveCursorKey is the cursor key of the live job, set for the backfill jobs reading the same cursor.
+	liveCursorKey string
+}
+
+type JobDBConfig struct {
+	SelectQuery string       `yaml:"selectQuery" env:"SELECT_QUERY" env-default:"SELECT MAX(id) as id, partition_key FROM sample_table WHERE id > $1 GROUP BY partition_key"` //nolint:lll
+	Cursor      CursorConfig `yaml:"cursor" env-prefix:"CURSOR_"`
+
+	// ColumnTypes overrides the type used to decode the values of the columns (e.g. "text", "numeric", "json",
+	// "time", "hex"), by default the type is inferred from the database type of the column.
+	ColumnTypes map[string]string `yaml:"columnTypes" env:"COLUMN_TYPES"`
+
+	// Params are the values of the named placeholders used in the select query, besides :cursor and :batch_size.
+	Params map[string]string `yaml:"params" env:"PARAMS"`
+
+	// Backfill defines how the whole table is walked in backfill mode.
+	Backfill BackfillConfig `yaml:"backfill" env-prefix:"BACKFILL_"`
+
+	// queryParams are the names of the parameters of the select query in the order of the query arguments, set when
+	// the query uses named placeholders.
+	queryParams []string
+}
+
+type JobRedisConfig struct {
+	Key       string `yaml:"key" env:"KEY" env-default:"my-worker:${partition_key}:key"`
+	Value     string `yaml:"value" env:"VALUE" env-default:"${id}"`
+	CursorKey string `yaml:"cursorKey" env:"CURSOR_KEY" env-default:"my-worker:latest"`
+
+	// MarkerKey is the key written along with each batch to detect when redis lost data, e.g. restarted without
+	// persistence or failed over to a stale replica, resyncing the rows from the last cursor found in redis. It's
+	// seeded on the first run, also in the cursor stores outside of redis. In cluster and ring modes, only the loss of
+	// the node or shard of the marker is detected. When empty, data loss is not detected.
+	MarkerKey string `yaml:"markerKey" env:"MARKER_KEY"`
+
+	// Mode is the way rows are written into redis: "string" sets the key to the value template and "hash" sets the
+	// row columns as fields of the hash stored at key.
+	Mode string `yaml:"mode" env:"MODE" env-default:"string"`
+
+	// ValueFormat is the format of the value in "string" mode: "template" uses the value template, "json" and
+	// "msgpack" serialize the row columns defined by Fields.
+	ValueFormat string `yaml:"valueFormat" env:"VALUE_FORMAT" env-default:"template"`
+
+	// Fields is the mapping of column names to field names used in "hash" mode and when serializing the value. When
+	// empty, all the columns are written using the column names as field names.
+	Fields map[string]string `yaml:"fields" env:"FIELDS"`
+
+	// KeyNull defines how null values of the columns referenced in the key template are handled.
+	KeyNull NullPolicyConfig `yaml:"keyNull" env-prefix:"KEY_NULL_"`
+
+	// ValueNull defines how null values of the columns referenced in the value template, or the hash fields in "hash"
+	// mode, are handled.
+	ValueNull NullPolicyConfig `yaml:"valueNull" env-prefix:"VALUE_NULL_"`
+
+	// DeleteWhen defines the condition that marks a row as deleted, the key of deleted rows is removed instead of set.
+	DeleteWhen DeleteConditionConfig `yaml:"deleteWhen" env-prefix:"DELETE_WHEN_"`
+
+	// DeleteCommand is the command used to remove keys: "del" or "unlink".
+	DeleteCommand string `yaml:"deleteCommand" env:"DELETE_COMMAND" env-default:"del"`
+
+	// TimestampKey is the key to store the timestamp that periodically gets written into redis to mark that the
+	// worker is alive and processing rows (every worker poll).
+	TimestampKey string `yaml:"timestampKey" env:"WRITER_TIMESTAMP_KEY"`
+
+	// Transactional determines whether each batch is wrapped in a MULTI/EXEC transaction, so the keys of the batch are
+	// applied together. Redis doesn't roll back the commands that fail within the transaction (e.g. WRONGTYPE), so the
+	// cursor is written after the transaction, once every command succeeded. The cursor is not written atomically
+	// with the keys: when the worker stops between both, the batch is written again (at-least-once). Not supported in
+	// cluster and ring modes.
+	Transactional bool `yaml:"transactional" env:"TRANSACTIONAL" env-default:"false"`
+
+	// TTL is the expiration of the keys, either a fixed duration (e.g. "24h") or a template referencing a column
+	// (e.g. "${expires_at}") that contains an absolute time or a number of seconds. When empty, keys don't expire.
+	// Rows with a past time or a number of seconds lower or equal to 0 delete their key.
+	TTL string `yaml:"ttl" env:"TTL"`
+
+	// TTLJitter is the maximum random duration added to the expiration of each key, to avoid mass expiry.
+	TTLJitter time.Duration `yaml:"ttlJitter" env:"TTL_JITTER"`
+
+	// VersionColumn is the column containing the version of the row (e.g. a sequence or an updated_at time). When
+	// set, a key is only written when the version of the row is greater than the version stored in redis, which
+	// prevents overwriting newer values with older ones.
+	VersionColumn string `yaml:"versionColumn" env:"VERSION_COLUMN"`
+
+	// VersionKeySuffix is appended to the key of each row to build the key that stores its version.
+	VersionKeySuffix string `yaml:"versionKeySuffix" env:"VERSION_KEY_SUFFIX" env-default:":version"`
+
+	// Generation prefixes the keys with a versioned generation name, to rebuild the cache with a different layout
+	// without consumers seeing a partially filled cache.
+	Generation GenerationConfig `yaml:"generation" env-prefix:"GENERATION_"`
 }
 
+// DBConfig contains the database connection settings. DriverName is one of "postgres", "mysql", "sqlite3" or
+// "sqlserver", for sqlite3 DBName is the path of the database file.
 type DBConfig struct {
 	// ConnectionString is the full connection string to the database. If ConnectionString is provided, the other fields
 	// are ignored.
-	ConnectionString string       `yaml:"connectionString" env:"CONNECTION_STRING"`
-	DriverName       string       `yaml:"driverName" env:"DRIVER_NAME" env-default:"postgres"`
-	SelectQuery      string       `yaml:"selectQuery" env:"SELECT_QUERY" env-default:"SELECT MAX(id) as id, partition_key FROM sample_table WHERE id > $1 GROUP BY partition_key"` //nolint:lll
-	Cursor           CursorConfig `yaml:"cursor" env-prefix:"CURSOR_"`
-	Host             string       `yaml:"host" env:"HOST" env-default:"localhost"`
-	Port             int          `yaml:"port" env:"PORT" env-default:"5432"`
-	User             string       `yaml:"user" env:"USER" env-default:"postgres"`
-	Password         string       `yaml:"password" env:"PASSWORD" env-default:"postgres"`
-	DBName           string       `yaml:"dbName" env:"DBNAME" env-default:"postgres"`
-	TLS              DBTLSConfig  `yaml:"tls" env-prefix:"TLS_"`
+	ConnectionString string      `yaml:"connectionString" env:"CONNECTION_STRING"`
+	DriverName       string      `yaml:"driverName" env:"DRIVER_NAME" env-default:"postgres"`
+	Host             string      `yaml:"host" env:"HOST" env-default:"localhost"`
+	Port             int         `yaml:"port" env:"PORT" env-default:"5432"`
+	User             string      `yaml:"user" env:"USER" env-default:"postgres"`
+	Password         string      `yaml:"password" env:"PASSWORD" env-default:"postgres"`
+	DBName           string      `yaml:"dbName" env:"DBNAME" env-default:"postgres"`
+	TLS              DBTLSConfig `yaml:"tls" env-prefix:"TLS_"`
+
+	// JobDBConfig contains the select query and cursor of the default job, used when no jobs are defined.
+	JobDBConfig `yaml:",inline"`
 }
 
 type DBTLSConfig struct {
@@ -43,67 +162,228 @@ type DBTLSConfig struct {
 }
 
 type RedisConfig struct {
+	// RedisConnectionConfig contains the connection settings of the redis deployment, used when no targets are
+	// defined.
+	RedisConnectionConfig `yaml:",inline"`
+
+	// Targets are the redis deployments that receive every batch, each one tracking its own cursor so a lagging or
+	// unavailable target doesn't block the others. The jobs of each target are named "<job>/<target>" in the logs and
+	// metrics. When empty, the connection settings above are used.
+	Targets []RedisTargetConfig `yaml:"targets"`
+
+	// JobRedisConfig contains the key and value templates of the default job, used when no jobs are defined.
+	JobRedisConfig `yaml:",inline"`
+}
+
+// RedisConnectionConfig contains the settings to connect to a single redis server, a redis cluster or a
+// Sentinel-managed redis.
+type RedisConnectionConfig struct {
 	// URL is the connection string to the redis server. If URL is provided, the other fields are ignored.
-	URL       string         `yaml:"url" env:"URL"`
-	Host      string         `yaml:"host" env:"HOST" env-default:"localhost"`
-	Port      int            `yaml:"port" env:"PORT" env-default:"6379"`
-	User      string         `yaml:"user" env:"USER"`
-	Password  string         `yaml:"password" env:"PASSWORD"`
-	TLS       RedisTLSConfig `yaml:"tls" env-prefix:"TLS_"`
-	Key       string         `yaml:"key" env:"KEY" env-default:"my-worker:${partition_key}:key"`
-	Value     string         `yaml:"value" env:"VALUE" env-default:"${id}"`
-	CursorKey string         `yaml:"cursorKey" env:"CURSOR_KEY" env-default:"my-worker:latest"`
+	URL      string         `yaml:"url" env:"URL"`
+	Host     string         `yaml:"host" env:"HOST" env-default:"localhost"`
+	Port     int            `yaml:"port" env:"PORT" env-default:"6379"`
+	User     string         `yaml:"user" env:"USER"`
+	Password string         `yaml:"password" env:"PASSWORD"`
+	TLS      RedisTLSConfig `yaml:"tls" env-prefix:"TLS_"`
+
+	// Addrs are the seed addresses of a redis cluster or the addresses of the sentinels when MasterName is set. When
+	// provided, Host and Port are ignored.
+	Addrs []string `yaml:"addrs" env:"ADDRS" env-separator:","`
+
+	// Cluster determines whether the URL or Addrs point to a redis cluster, it's implied when multiple Addrs are
+	// provided without a MasterName.
+	Cluster bool `yaml:"cluster" env:"CLUSTER" env-default:"false"`
+
+	// MasterName is the name of the master monitored by the sentinels, enables the Sentinel failover client.
+	MasterName       string `yaml:"masterName" env:"MASTER_NAME"`
+	SentinelUser     string `yaml:"sentinelUser" env:"SENTINEL_USER"`
+	SentinelPassword string `yaml:"sentinelPassword" env:"SENTINEL_PASSWORD"`
+
+	// Ring maps shard names to the addresses of standalone redis instances, keys are distributed across the shards
+	// using consistent hashing on the shard names. When provided, the other addresses are ignored.
+	Ring map[string]string `yaml:"ring"`
+
+	// CursorShard is the name of the ring shard that stores the cursor and timestamp keys. Defaults to the first
+	// shard name in alphabetical order.
+	CursorShard string `yaml:"cursorShard" env:"CURSOR_SHARD"`
+}
 
-	// TimestampKey is the key to store the timestamp that periodically gets written into redis to mark that the
-	// worker is alive and processing rows (every worker poll).
-	TimestampKey string `yaml:"timestampKey" env:"WRITER_TIMESTAMP_KEY"`
+// RedisTargetConfig is a named redis deployment that receives the writes of all the jobs.
+type RedisTargetConfig struct {
+	Name                  string `yaml:"name"`
+	RedisConnectionConfig `yaml:",inline"`
 }
 
+const (
+	ModeString = "string"
+	ModeHash   = "hash"
+
+	ValueFormatTemplate = "template"
+	ValueFormatJSON     = "json"
+	ValueFormatMsgpack  = "msgpack"
+)
+
+// NullPolicyConfig defines how null values are handled in a template.
+type NullPolicyConfig struct {
+	// Policy is one of "ignore" (format the null value as is), "skip" (skip the row), "delete" (delete the key),
+	// "default" (use the Default text) or "fail" (fail the batch).
+	Policy  string `yaml:"policy" env:"POLICY" env-default:"ignore"`
+	Default string `yaml:"default" env:"DEFAULT"`
+}
+
+// DeleteConditionConfig defines the condition on a column that marks a row as deleted, e.g. deleted_at not null or
+// op equals "D".
+type DeleteConditionConfig struct {
+	// Column is the name of the column evaluated. When empty, rows are never deleted.
+	Column  string `yaml:"column" env:"COLUMN"`
+	NotNull bool   `yaml:"notNull" env:"NOT_NULL"`
+	Equals  string `yaml:"equals" env:"EQUALS"`
+}
+
+const (
+	DeleteCommandDel    = "del"
+	DeleteCommandUnlink = "unlink"
+)
+
+const (
+	NullPolicyIgnore  = "ignore"
+	NullPolicySkip    = "skip"
+	NullPolicyDelete  = "delete"
+	NullPolicyDefault = "default"
+	NullPolicyFail    = "fail"
+)
+
+var (
+	// ErrSkipRow is returned by the template functions when the row should not be written.
+	ErrSkipRow = errors.New("row skipped")
+	// ErrDeleteKey is returned by the template functions when the key should be deleted.
+	ErrDeleteKey = errors.New("key deleted")
+	// ErrKeyExpired is returned by the expiration functions when the key of the row is already expired.
+	ErrKeyExpired = errors.New("key expired")
+	// ErrNullValue is returned by the template functions when a null value is not allowed.
+	ErrNullValue = errors.New("null value")
+)
+
 type RedisTLSConfig struct {
 	InsecureSkipVerify bool `yaml:"insecureSkipVerify" env:"INSECURE_SKIP_VERIFY" env-default:"false"`
 }
 
+// Types of cursor stores.
+const (
+	CursorStoreRedis = "redis"
+	CursorStoreSQL   = "sql"
+	CursorStoreFile  = "file"
+)
+
+// CursorStoreConfig defines the stores of the job cursors. When using more than one store, the cursor is written to
+// all of them and the lowest stored value is used, so a store that lost its data doesn't cause rows to be skipped.
+type CursorStoreConfig struct {
+	Types []string `yaml:"types" env:"TYPES" env-separator:"," env-default:"redis"`
+	// Table is the db table of the sql store, with cursor_key and cursor_value text columns.
+	Table string `yaml:"table" env:"TABLE" env-default:"worker_cursors"`
+	// File is the path of the json file of the file store.
+	File string `yaml:"file" env:"FILE" env-default:"cursors.json"`
+}
+
+// CursorConfig defines the column used to resume the select query. A compound cursor is defined with comma-separated
+// columns (e.g. updated_at,id), each one with its own type and default, or a single one applied to all the columns.
 type CursorConfig struct {
 	Column  string `yaml:"column" env:"COLUMN" env-default:"id"`
 	Type    string `yaml:"type" env:"TYPE" env-default:"int64"`
 	Default string `yaml:"default" env:"DEFAULT" env-default:"-1"`
+	// TimeFormat is the layout used to store timestamp cursors in redis, or unix, unixmilli and unixmicro to store the
+	// time since epoch, followed by the nanoseconds below the unit as a fraction (e.g. "1727785800.0000005"). The
+	// layouts should keep the nanoseconds, so the cursor doesn't get stuck on truncated values. Defaults to RFC 3339
+	// with nanoseconds.
+	TimeFormat string `yaml:"timeFormat" env:"TIME_FORMAT"`
+	// Lookback re-reads a trailing range of the cursor on each poll, to catch the rows committed after rows with a
+	// greater cursor value.
+	Lookback LookbackConfig `yaml:"lookback" env-prefix:"LOOKBACK_"`
+}
+
+// LookbackConfig defines the trailing range of the cursor that is re-read on each poll. With integer cursors, the
+// missing ids in the range are tracked as gaps and the stored cursor only advances once they are filled or expired.
+// With timestamp cursors, the stored cursor trails the current time by the window.
+type LookbackConfig struct {
+	IDs        int64         `yaml:"ids" env:"IDS"`
+	Window     time.Duration `yaml:"window" env:"WINDOW"`
+	GapTimeout time.Duration `yaml:"gapTimeout" env:"GAP_TIMEOUT"`
 }
 
 type (
 	ComparatorFunc func(a, b any) (int, error)
 	ConvertFunc    func(value string) (any, error)
-	KeyFunc        func(row map[string]any) string
-	ValueFunc      func(row map[string]any) any
+	KeyFunc        func(row map[string]any) (string, error)
+	ValueFunc      func(row map[string]any) (any, error)
+	FieldsFunc     func(row map[string]any) (map[string]any, error)
+	DeleteFunc     func(row map[string]any) bool
+	VersionFunc    func(row map[string]any) (string, error)
 )
 
-func (c *DBConfig) BuildConnectionString() (string, error) {
-	if c.ConnectionString != "" {
-		return c.ConnectionString, nil
+// Validate checks that the null policy is supported, the delete policy is only allowed when the key is known.
+func (c *NullPolicyConfig) Validate(allowDelete bool) error {
+	switch c.Policy {
+	case NullPolicyIgnore, NullPolicySkip, NullPolicyDefault, NullPolicyFail:
+		return nil
+	case NullPolicyDelete:
+		if allowDelete {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported null policy: %s", c.Policy)
+}
+
+// AllTargets returns the redis targets that receive the writes, a single unnamed target built from the connection
+// settings when no targets are defined.
+func (c *RedisConfig) AllTargets() []RedisTargetConfig {
+	if len(c.Targets) == 0 {
+		return []RedisTargetConfig{{RedisConnectionConfig: c.RedisConnectionConfig}}
 	}
+	return c.Targets
+}
 
-	if c.DriverName != "postgres" {
-		return "", fmt.Errorf("unsupported driver to build the connection string: %s", c.DriverName)
+// DefaultJob returns the job defined by the top-level db and redis sections.
+func (c *Config) DefaultJob() JobConfig {
+	return JobConfig{
+		Name:      "default",
+		DB:        c.DB.JobDBConfig,
+		Redis:     c.Redis.JobRedisConfig,
+		PollDelay: c.PollDelay,
+		BatchSize: c.BatchSize,
+		Drain:     c.Drain,
 	}
+}
 
-	result := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		c.Host, c.Port, c.User, c.Password, c.DBName, c.TLS.Mode)
+func (c *DBConfig) BuildConnectionString() (string, error) {
+	if c.ConnectionString != "" {
+		return c.ConnectionString, nil
+	}
 
-	if c.TLS.RootCert != "" {
-		result += fmt.Sprintf(" sslrootcert=%s", c.TLS.RootCert)
+	dialect, err := c.Dialect()
+	if err != nil {
+		return "", fmt.Errorf("unable to build the connection string: %w", err)
 	}
 
-	return result, nil
+	return dialect.ConnectionString(c)
 }
 
-func (c *RedisConfig) ClientOptions() (*redis.Options, error) {
+// UniversalOptions returns the options to connect to a single redis server, a redis cluster or a Sentinel-managed
+// redis.
+func (c *RedisConnectionConfig) UniversalOptions() (*redis.UniversalOptions, error) {
 	if c.URL != "" {
-		return redis.ParseURL(c.URL)
+		return c.urlOptions()
 	}
 
-	opts := &redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", c.Host, c.Port),
-		Username: c.User,
-		Password: c.Password,
+	opts := &redis.UniversalOptions{
+		Addrs:            c.Addrs,
+		Username:         c.User,
+		Password:         c.Password,
+		MasterName:       c.MasterName,
+		SentinelUsername: c.SentinelUser,
+		SentinelPassword: c.SentinelPassword,
+	}
+	if len(opts.Addrs) == 0 {
+		opts.Addrs = []string{fmt.Sprintf("%s:%d", cmp.Or(c.Host, "localhost"), cmp.Or(c.Port, 6379))}
 	}
 
 	if c.TLS.InsecureSkipVerify {
@@ -115,137 +395,241 @@ func (c *RedisConfig) ClientOptions() (*redis.Options, error) {
 	return opts, nil
 }
 
-func (c *RedisConfig) KeyFn(logger *zap.Logger) (KeyFunc, error) {
-	formatString, columnNames, err := parseColumns(c.Key)
+func (c *RedisConnectionConfig) urlOptions() (*redis.UniversalOptions, error) {
+	if c.MasterName != "" {
+		return nil, errors.New("redis url is not supported with a sentinel master name")
+	}
+
+	if c.Cluster {
+		opts, err := redis.ParseClusterURL(c.URL)
+		if err != nil {
+			return nil, err
+		}
+		return &redis.UniversalOptions{
+			Addrs:     opts.Addrs,
+			Username:  opts.Username,
+			Password:  opts.Password,
+			TLSConfig: opts.TLSConfig,
+		}, nil
+	}
+
+	opts, err := redis.ParseURL(c.URL)
 	if err != nil {
 		return nil, err
 	}
+	return &redis.UniversalOptions{
+		Addrs:     []string{opts.Addr},
+		Username:  opts.Username,
+		Password:  opts.Password,
+		DB:        opts.DB,
+		TLSConfig: opts.TLSConfig,
+	}, nil
+}
 
-	logger.Info("Using redis key", zap.String("key", formatString), zap.Any("columnNames", columnNames))
+// NewClient returns the redis client for the topology defined in the config: client-side sharding when Ring is set,
+// Sentinel failover when MasterName is set, cluster when Cluster is set or multiple Addrs are provided, or a single
+// server otherwise.
+func (c *RedisConnectionConfig) NewClient() (redis.UniversalClient, error) {
+	opts, err := c.UniversalOptions()
+	if err != nil {
+		return nil, err
+	}
 
-	return func(row map[string]any) string {
-		args := make([]any, 0, len(columnNames))
-		for _, name := range columnNames {
-			value := row[name]
-			args = append(args, value)
-		}
+	switch {
+	case len(c.Ring) > 0:
+		return redis.NewRing(c.ringOptions(opts)), nil
+	case opts.MasterName != "":
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case c.Cluster || len(opts.Addrs) > 1:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	}
+	return redis.NewClient(opts.Simple()), nil
+}
 
-		return fmt.Sprintf(formatString, args...)
-	}, nil
+// Sharded returns true when the keys are spread across multiple nodes, by a redis cluster or a ring.
+func (c *RedisConnectionConfig) Sharded() bool {
+	if len(c.Ring) > 0 {
+		return true
+	}
+	return c.MasterName == "" && (c.Cluster || (c.URL == "" && len(c.Addrs) > 1))
 }
 
-func (c *RedisConfig) ValueFn(logger *zap.Logger) (ValueFunc, error) {
-	formatString, columnNames, err := parseColumns(c.Value)
+func (c *JobRedisConfig) KeyFn(logger *zap.Logger) (KeyFunc, error) {
+	t, err := parseTemplate(c.Generation.Prefix() + c.Key)
 	if err != nil {
 		return nil, err
 	}
+	t.nullPolicy = c.KeyNull
 
-	logger.Info("Using redis value", zap.String("value", formatString), zap.Any("columnNames", columnNames))
+	logger.Info("Using redis key", zap.String("key", t.text), zap.Any("columnNames", t.columns()))
 
-	return func(row map[string]any) any {
-		if len(columnNames) == 1 && formatString == "%v" {
-			// Use the same type
-			return row[columnNames[0]]
-		}
+	return t.execute, nil
+}
 
-		args := make([]any, 0, len(columnNames))
-		for _, name := range columnNames {
-			value := row[name]
-			args = append(args, value)
-		}
+// KeyPattern returns the redis glob-style pattern matching the keys of the job, used to scan the keys in redis.
+func (c *JobRedisConfig) KeyPattern() (string, error) {
+	t, err := parseTemplate(c.Generation.Prefix() + c.Key)
+	if err != nil {
+		return "", err
+	}
+	return t.pattern(), nil
+}
 
-		return fmt.Sprintf(formatString, args...)
-	}, nil
+// KeyPatterns returns the redis glob-style patterns matching the keys written by the job, the keys of the rows and
+// their version keys.
+func (c *JobRedisConfig) KeyPatterns() ([]string, error) {
+	pattern, err := c.KeyPattern()
+	if err != nil {
+		return nil, err
+	}
+	if c.VersionColumn == "" {
+		return []string{pattern}, nil
+	}
+	return []string{pattern, pattern + globEscaper.Replace(c.VersionKeySuffix)}, nil
 }
 
-func parseColumns(text string) (string, []string, error) {
-	matches := parameterRegex.FindAllStringSubmatchIndex(text, -1)
-	if len(matches) == 0 {
-		return "", nil, fmt.Errorf("no parameters found in key/value: %s", text)
+// KeyHashTagged returns true when the key template contains a hash tag (e.g. "{user:${id}}"), so the keys derived
+// from the key, like the version key, are stored in the same hash slot of a redis cluster or shard of a ring.
+func (c *JobRedisConfig) KeyHashTagged() (bool, error) {
+	t, err := parseTemplate(c.Generation.Prefix() + c.Key)
+	if err != nil {
+		return false, err
 	}
+	return t.hashTagged(), nil
+}
 
-	formatString := ""
-	index := 0
-	columnNames := make([]string, 0, len(matches))
-	for _, match := range matches {
-		formatString += text[index:match[0]] + "%v"
-		index = match[1]
-		column := text[match[2]:match[3]]
-		columnNames = append(columnNames, column)
+func (c *JobRedisConfig) ValueFn(logger *zap.Logger) (ValueFunc, error) {
+	switch c.ValueFormat {
+	case "", ValueFormatTemplate:
+		// Continue below
+	case ValueFormatJSON, ValueFormatMsgpack:
+		return c.serializedValueFn(logger)
+	default:
+		return nil, fmt.Errorf("unsupported value format: %s", c.ValueFormat)
 	}
 
-	if index < len(text) {
-		formatString += text[index:]
+	t, err := parseTemplate(c.Value)
+	if err != nil {
+		return nil, err
+	}
+	t.nullPolicy = c.ValueNull
+
+	logger.Info("Using redis value", zap.String("value", t.text), zap.Any("columnNames", t.columns()))
+
+	if segment, ok := t.single(); ok {
+		return func(row map[string]any) (any, error) {
+			// Use the same type
+			value, err := segment.eval(row)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				if value, err = t.nullValue(segment); err != nil {
+					return nil, err
+				}
+			}
+			return formatValue(value), nil
+		}, nil
 	}
 
-	return formatString, columnNames, nil
+	return func(row map[string]any) (any, error) {
+		return t.execute(row)
+	}, nil
 }
 
-func (c *CursorConfig) Info() (*CursorInfo, error) {
-	convertFunc, compareFunc, err := toDBTypeFuncs(c.Type)
+// FieldsFn returns a function that maps the row columns to the hash fields.
+func (c *JobRedisConfig) FieldsFn(logger *zap.Logger) (FieldsFunc, error) {
+	columnsFn, err := c.columnsFn(logger)
 	if err != nil {
 		return nil, err
 	}
 
-	defaultValue, err := convertFunc(c.Default)
-	if err != nil {
-		return nil, fmt.Errorf("unable to convert default value: %w", err)
+	return func(row map[string]any) (map[string]any, error) {
+		fields, err := columnsFn(row)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, value := range fields {
+			if value == nil {
+				if value, err = c.nullField(name); err != nil {
+					return nil, err
+				}
+			}
+			fields[name] = formatValue(value)
+		}
+		return fields, nil
+	}, nil
+}
+
+// nullField returns the value used for a null hash field, according to the value null policy.
+func (c *JobRedisConfig) nullField(name string) (any, error) {
+	switch c.ValueNull.Policy {
+	case NullPolicySkip:
+		return nil, ErrSkipRow
+	case NullPolicyDelete:
+		return nil, ErrDeleteKey
+	case NullPolicyFail:
+		return nil, fmt.Errorf("%w: %s", ErrNullValue, name)
+	case NullPolicyDefault:
+		return c.ValueNull.Default, nil
+	}
+	return nil, nil
+}
+
+// DeleteFn returns a function that determines whether the row is marked as deleted, it returns nil when no
+// condition is defined.
+func (c *DeleteConditionConfig) DeleteFn(logger *zap.Logger) (DeleteFunc, error) {
+	if c.Column == "" {
+		return nil, nil
+	}
+
+	if c.NotNull == (c.Equals != "") {
+		return nil, fmt.Errorf("delete condition on column '%s' should define either notNull or equals", c.Column)
+	}
+
+	logger.Info("Using delete condition",
+		zap.String("column", c.Column), zap.Bool("notNull", c.NotNull), zap.String("equals", c.Equals))
+
+	if c.NotNull {
+		return func(row map[string]any) bool {
+			return row[c.Column] != nil
+		}, nil
 	}
 
-	return &CursorInfo{
-		Column:      c.Column,
-		Type:        c.Type,
-		Default:     defaultValue,
-		CompareFunc: compareFunc,
-		ConvertFunc: convertFunc,
+	return func(row map[string]any) bool {
+		value := row[c.Column]
+		return value != nil && fmt.Sprint(formatValue(value)) == c.Equals
 	}, nil
 }
 
-type CursorInfo struct {
-	Column      string
-	Type        string
-	Default     any
-	CompareFunc ComparatorFunc
-	ConvertFunc ConvertFunc
-}
-
-func toDBTypeFuncs(dbType string) (ConvertFunc, ComparatorFunc, error) {
-	switch dbType {
-	case "int64":
-		return func(value string) (any, error) {
-			return strconv.ParseInt(value, 10, 64)
-		}, getCompareFunc[int64](), nil
-	case "int32":
-		return func(value string) (any, error) {
-			return strconv.ParseInt(value, 10, 32)
-		}, getCompareFunc[int64](), nil
-	case "int":
-		return func(value string) (any, error) {
-			return strconv.Atoi(value)
-		}, getCompareFunc[int](), nil
-	case "string":
-		return func(value string) (any, error) {
-			return value, nil
-		}, getCompareFunc[string](), nil
-	case "uuid":
-		return func(value string) (any, error) {
-			return value, nil
-		}, getCompareFunc[string](), nil
-	}
-
-	return nil, nil, fmt.Errorf("unsupported type: %s", dbType)
-}
-
-func getCompareFunc[T cmp.Ordered]() ComparatorFunc {
-	return func(a, b any) (int, error) {
-		aComparable, ok := a.(T)
-		if !ok {
-			return 0, fmt.Errorf("unable to convert a to %T", aComparable)
-		}
-		bComparable, ok := b.(T)
-		if !ok {
-			return 0, fmt.Errorf("unable to convert a to %T", bComparable)
+// columnsFn returns a function that selects and renames the row columns defined by Fields, keeping the decoded types.
+func (c *JobRedisConfig) columnsFn(logger *zap.Logger) (FieldsFunc, error) {
+	if len(c.Fields) == 0 {
+		logger.Info("Using all columns as redis fields")
+		return func(row map[string]any) (map[string]any, error) {
+			return maps.Clone(row), nil
+		}, nil
+	}
+
+	for column, field := range c.Fields {
+		if column == "" || field == "" {
+			return nil, fmt.Errorf("invalid field mapping '%s' to '%s'", column, field)
 		}
-		return cmp.Compare(aComparable, bComparable), nil
 	}
+
+	logger.Info("Using redis fields", zap.Any("fields", c.Fields))
+
+	return func(row map[string]any) (map[string]any, error) {
+		result := make(map[string]any, len(c.Fields))
+		for column, field := range c.Fields {
+			value, ok := row[column]
+			if !ok {
+				return nil, fmt.Errorf("column '%s' does not exist", column)
+			}
+			result[field] = value
+		}
+
+		return result, nil
+	}, nil
 }
@@ -3,10 +3,12 @@ package config
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -15,7 +17,7 @@ func TestRunner(t *testing.T) {
 	RunSpecs(t, "Config Suite")
 }
 
-var _ = Describe("RedisConfig", func() {
+var _ = Describe("JobRedisConfig", func() {
 	logger, err := zap.NewDevelopment()
 	Expect(err).NotTo(HaveOccurred())
 	row := map[string]any{
@@ -25,7 +27,7 @@ var _ = Describe("RedisConfig", func() {
 
 	Describe("KeyFn()", func() {
 		It("should fail if no columns are found in key", func() {
-			c := RedisConfig{Key: "worker"}
+			c := JobRedisConfig{Key: "worker"}
 			_, err := c.KeyFn(logger)
 			Expect(err).To(HaveOccurred())
 		})
@@ -39,12 +41,53 @@ var _ = Describe("RedisConfig", func() {
 
 		for _, test := range tests {
 			It(fmt.Sprintf("should return a function that parses a key for %s", test[0]), func() {
-				c := RedisConfig{Key: test[0]}
+				c := JobRedisConfig{Key: test[0]}
 				fn, err := c.KeyFn(logger)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(fn(row)).To(Equal(test[1]))
 			})
 		}
+
+		It("should prefix the key with the generation", func() {
+			c := JobRedisConfig{Key: "worker:${id}", Generation: GenerationConfig{Name: "v2", PointerKey: "worker"}}
+			fn, err := c.KeyFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(row)).To(Equal("v2:worker:1"))
+		})
+	})
+
+	Describe("KeyPattern()", func() {
+		It("should replace the placeholders and escape the special characters", func() {
+			c := JobRedisConfig{Key: "worker:${id|pad:4}:[${hello}]*", Generation: GenerationConfig{Name: "v2"}}
+			Expect(c.KeyPattern()).To(Equal(`v2:worker:*:\[*\]\*`))
+		})
+	})
+
+	Describe("KeyPatterns()", func() {
+		It("should include the pattern of the version keys", func() {
+			c := JobRedisConfig{Key: "worker:${id}", Generation: GenerationConfig{Name: "v2"}}
+			Expect(c.KeyPatterns()).To(Equal([]string{"v2:worker:*"}))
+
+			c.VersionColumn = "updated_at"
+			c.VersionKeySuffix = ":version"
+			Expect(c.KeyPatterns()).To(Equal([]string{"v2:worker:*", "v2:worker:*:version"}))
+		})
+	})
+
+	Describe("KeyHashTagged()", func() {
+		It("should find the hash tag in the literal text", func() {
+			tests := map[string]bool{
+				"{user:${id}}:profile": true,
+				"user:{${id}}":         true,
+				"user:${id}":           false,
+				"user:{}:${id}":        false,
+				"user:}{${id}":         false,
+			}
+			for key, expected := range tests {
+				c := JobRedisConfig{Key: key, Generation: GenerationConfig{Name: "v2"}}
+				Expect(c.KeyHashTagged()).To(Equal(expected), key)
+			}
+		})
 	})
 
 	Describe("ValueFn()", func() {
@@ -61,11 +104,155 @@ var _ = Describe("RedisConfig", func() {
 
 		for _, test := range tests {
 			It(fmt.Sprintf("should return a function that parses a value for %s", test.text), func() {
-				c := RedisConfig{Value: test.text}
+				c := JobRedisConfig{Value: test.text}
 				fn, err := c.ValueFn(logger)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(fn(row)).To(Equal(test.expected))
 			})
 		}
 	})
+
+	Describe("FieldsFn()", func() {
+		It("should return all the columns when no fields are defined", func() {
+			c := JobRedisConfig{}
+			fn, err := c.FieldsFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(row)).To(Equal(row))
+		})
+
+		It("should map the columns to fields", func() {
+			c := JobRedisConfig{Fields: map[string]string{"hello": "greeting"}}
+			fn, err := c.FieldsFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(row)).To(Equal(map[string]any{"greeting": "world"}))
+		})
+
+		It("should fail when the column does not exist", func() {
+			c := JobRedisConfig{Fields: map[string]string{"other": "other"}}
+			fn, err := c.FieldsFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = fn(row)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("DeleteFn()", func() {
+		It("should return nil when no column is defined", func() {
+			c := DeleteConditionConfig{}
+			fn, err := c.DeleteFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn).To(BeNil())
+		})
+
+		It("should match not null values", func() {
+			c := DeleteConditionConfig{Column: "deleted_at", NotNull: true}
+			fn, err := c.DeleteFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(map[string]any{"deleted_at": time.Now()})).To(BeTrue())
+			Expect(fn(map[string]any{"deleted_at": nil})).To(BeFalse())
+		})
+
+		It("should match equal values", func() {
+			c := DeleteConditionConfig{Column: "op", Equals: "D"}
+			fn, err := c.DeleteFn(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fn(map[string]any{"op": []byte("D")})).To(BeTrue())
+			Expect(fn(map[string]any{"op": "U"})).To(BeFalse())
+			Expect(fn(map[string]any{"op": nil})).To(BeFalse())
+		})
+
+		It("should fail when the condition is not defined", func() {
+			c := DeleteConditionConfig{Column: "op"}
+			_, err := c.DeleteFn(logger)
+			Expect(err).To(HaveOccurred())
+
+			c = DeleteConditionConfig{Column: "op", NotNull: true, Equals: "D"}
+			_, err = c.DeleteFn(logger)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("RedisConnectionConfig", func() {
+	Describe("NewClient()", func() {
+		It("should return a single server client by default", func() {
+			c := RedisConnectionConfig{Host: "localhost", Port: 6379}
+			client, err := c.NewClient()
+			Expect(err).NotTo(HaveOccurred())
+			defer client.Close()
+			Expect(client).To(BeAssignableToTypeOf(&redis.Client{}))
+		})
+
+		It("should return a cluster client", func() {
+			for _, c := range []RedisConnectionConfig{
+				{Addrs: []string{"localhost:7000", "localhost:7001"}},
+				{Addrs: []string{"localhost:7000"}, Cluster: true},
+				{URL: "redis://localhost:7000?addr=localhost:7001", Cluster: true},
+			} {
+				client, err := c.NewClient()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(client).To(BeAssignableToTypeOf(&redis.ClusterClient{}))
+				Expect(client.Close()).To(Succeed())
+			}
+		})
+
+		It("should return a sentinel failover client", func() {
+			c := RedisConnectionConfig{Addrs: []string{"localhost:26379"}, MasterName: "main"}
+			opts, err := c.UniversalOptions()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(opts.MasterName).To(Equal("main"))
+
+			client, err := c.NewClient()
+			Expect(err).NotTo(HaveOccurred())
+			defer client.Close()
+			Expect(client).To(BeAssignableToTypeOf(&redis.Client{}))
+		})
+
+		It("should return a ring client", func() {
+			c := RedisConnectionConfig{Ring: map[string]string{"b": "localhost:6380", "a": "localhost:6379"}}
+			client, err := c.NewClient()
+			Expect(err).NotTo(HaveOccurred())
+			defer client.Close()
+			Expect(client).To(BeAssignableToTypeOf(&redis.Ring{}))
+		})
+
+		It("should fail when a url is used with sentinel", func() {
+			c := RedisConnectionConfig{URL: "redis://localhost:26379", MasterName: "main"}
+			_, err := c.NewClient()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("NewCursorClient()", func() {
+		It("should return nil when the ring is not used", func() {
+			c := RedisConnectionConfig{Host: "localhost", Port: 6379}
+			Expect(c.NewCursorClient()).To(BeNil())
+		})
+
+		It("should return the client of the cursor shard", func() {
+			c := RedisConnectionConfig{Ring: map[string]string{"b": "localhost:6380", "a": "localhost:6379"}}
+			client, err := c.NewCursorClient()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Options().Addr).To(Equal("localhost:6379"))
+			Expect(client.Close()).To(Succeed())
+
+			c.CursorShard = "b"
+			client, err = c.NewCursorClient()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Options().Addr).To(Equal("localhost:6380"))
+			Expect(client.Close()).To(Succeed())
+		})
+	})
+
+	Describe("ValidateRing()", func() {
+		It("should fail when the settings are not valid", func() {
+			for _, c := range []RedisConnectionConfig{
+				{CursorShard: "a"},
+				{Ring: map[string]string{"a": "localhost:6379"}, CursorShard: "b"},
+				{Ring: map[string]string{"a": "localhost:6379"}, Cluster: true},
+			} {
+				Expect(c.ValidateRing()).NotTo(Succeed(), "config %v", c)
+			}
+		})
+	})
 })
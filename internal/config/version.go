@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxVersionDecimals is the max number of decimals of a version.
+const maxVersionDecimals = 100
+
+// VersionFn returns a function that gets the version of a row as a decimal text without exponent, which can be
+// compared exactly by redis. Time values are converted to microseconds since epoch. Returns nil when no version column
+// is defined.
+func (c *JobRedisConfig) VersionFn() VersionFunc {
+	if c.VersionColumn == "" {
+		return nil
+	}
+
+	column := c.VersionColumn
+	return func(row map[string]any) (string, error) {
+		value, ok := row[column]
+		if !ok {
+			return "", fmt.Errorf("version column '%s' not found", column)
+		}
+		if value == nil {
+			return "", fmt.Errorf("%w: %s", ErrNullValue, column)
+		}
+
+		version, err := toVersion(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid version value for column '%s': %w", column, err)
+		}
+		return version, nil
+	}
+}
+
+func toVersion(value any) (string, error) {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return strconv.FormatInt(v.UnixMicro(), 10), nil
+	case json.Number:
+		return parseVersion(string(v))
+	case []byte:
+		return parseVersion(string(v))
+	case string:
+		return parseVersion(v)
+	}
+
+	return "", fmt.Errorf("unsupported type %T", value)
+}
+
+func parseVersion(value string) (string, error) {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return decimalVersion(value)
+	}
+
+	t, err := decodeTime(value)
+	if err != nil {
+		return "", fmt.Errorf("expected a number or a time: %w", err)
+	}
+	return toVersion(t)
+}
+
+// decimalVersion returns the number as a decimal without exponent, leading zeros in the integer part or trailing zeros
+// in the fraction, e.g. "1e3" as "1000" and "007.50" as "7.5".
+func decimalVersion(value string) (string, error) {
+	r, ok := new(big.Rat).SetString(value)
+	if !ok || strings.Contains(value, "/") {
+		return "", fmt.Errorf("invalid version number '%s'", value)
+	}
+
+	decimals := 0
+	for d := new(big.Rat).Set(r); !d.IsInt(); d.Mul(d, big.NewRat(10, 1)) {
+		if decimals++; decimals > maxVersionDecimals {
+			return "", fmt.Errorf("version number '%s' has more than %d decimals", value, maxVersionDecimals)
+		}
+	}
+	return r.FloatString(decimals), nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JobRedisConfig", func() {
+	updatedAt := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	Describe("VersionFn()", func() {
+		It("should return nil when the version column is not set", func() {
+			c := JobRedisConfig{}
+			Expect(c.VersionFn()).To(BeNil())
+		})
+
+		tests := []struct {
+			value    any
+			expected string
+		}{
+			{int64(12), "12"},
+			{int32(7), "7"},
+			{1.5, "1.5"},
+			{json.Number("123.45"), "123.45"},
+			{json.Number("0123.4500"), "123.45"},
+			{"1e3", "1000"},
+			{"-0.0", "0"},
+			{"9007199254740993", "9007199254740993"},
+			{[]byte("42"), "42"},
+			{updatedAt, "1727784000000000"},
+			{"2024-10-01 12:00:00Z", "1727784000000000"},
+		}
+
+		for _, test := range tests {
+			It("should return the version for "+fmt.Sprintf("%T", test.value), func() {
+				c := JobRedisConfig{VersionColumn: "version"}
+				fn := c.VersionFn()
+				Expect(fn(map[string]any{"version": test.value})).To(Equal(test.expected))
+			})
+		}
+
+		It("should fail when the version is null", func() {
+			c := JobRedisConfig{VersionColumn: "version"}
+			_, err := c.VersionFn()(map[string]any{"version": nil})
+			Expect(err).To(MatchError(ErrNullValue))
+		})
+
+		It("should fail when the version is not valid", func() {
+			c := JobRedisConfig{VersionColumn: "version"}
+			fn := c.VersionFn()
+			for _, row := range []map[string]any{{"id": 1}, {"version": "v1"}, {"version": "NaN"},
+				{"version": true}} {
+				_, err := fn(row)
+				Expect(err).To(HaveOccurred(), "row %v", row)
+			}
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Backfill walks the whole table in chunks from the checkpoint until a chunk is not full, as fast as the rows per
+// second limit allows, and removes the checkpoint once completed. With generations, the pointer is flipped to the
+// generation of the job once completed. The runner should be built with the backfill config of the job
+// (config.JobConfig.BackfillJob), so the cursor of the live worker is never modified.
+func (r *Runner) Backfill(ctx context.Context) error {
+	cursorInfo, err := r.cfg.DB.Cursor.Info()
+	if err != nil {
+		return err
+	}
+
+	fns, err := r.rowFuncs()
+	if err != nil {
+		return err
+	}
+
+	if generation := r.cfg.Redis.Generation; generation.Name != "" {
+		// Backfilling a retired generation rebuilds it, e.g. to flip the pointer back to it
+		if err := r.redisClient.SRem(ctx, generation.RetiredKey(), generation.Name).Err(); err != nil {
+			return fmt.Errorf("unable to restore generation %s: %w", generation.Name, err)
+		}
+	}
+
+	rowsPerSecond := r.cfg.DB.Backfill.RowsPerSecond
+	start := time.Now()
+	totalRows := 0
+	r.logger.Info("starting backfill", zap.String("checkpointKey", r.cfg.Redis.CursorKey),
+		zap.Int("batchSize", r.batchSize), zap.Int("rowsPerSecond", rowsPerSecond))
+
+	for {
+		readRows, _, advanced, err := r.runOnce(ctx, cursorInfo, fns)
+		if err != nil {
+			// The checkpoint is kept, the backfill resumes from it on the next run
+			return fmt.Errorf("backfill stopped: %w", err)
+		}
+		totalRows += readRows
+		if readRows < r.batchSize {
+			break
+		}
+		if !advanced {
+			return fmt.Errorf("backfill stopped: the checkpoint did not advance with a full chunk of %d rows, "+
+				"consider incrementing the batch size", r.batchSize)
+		}
+
+		if rowsPerSecond > 0 {
+			delay := time.Duration(float64(totalRows)/float64(rowsPerSecond)*float64(time.Second)) - time.Since(start)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	if r.cfg.Redis.Generation.Name != "" {
+		if err := r.seedLiveCursor(ctx, cursorInfo); err != nil {
+			return err
+		}
+		if err := r.flipGeneration(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, store := range r.cursorStores {
+		if err := store.Delete(ctx, r.cfg.Redis.CursorKey); err != nil {
+			return fmt.Errorf("unable to delete checkpoint from %s store: %w", store.Name(), err)
+		}
+	}
+	r.logger.Info("backfill completed", zap.Int("rows", totalRows), zap.Duration("elapsed", time.Since(start)))
+	return nil
+}
@@ -0,0 +1,215 @@
+package runner
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jorgebay/write-behind-cache-worker/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// CursorStore reads and writes the cursor of the jobs.
+type CursorStore interface {
+	// Get returns the stored cursor, or an empty string when not found.
+	Get(ctx context.Context, key string) (string, error)
+	// Set stores the cursor.
+	Set(ctx context.Context, key string, value string) error
+	// Delete removes the cursor, e.g. the checkpoint of a completed backfill.
+	Delete(ctx context.Context, key string) error
+	// Name returns the name of the store, used in logs and errors.
+	Name() string
+}
+
+// WithCursorStores sets the stores of the cursor, redis by default.
+func WithCursorStores(stores ...CursorStore) Option {
+	return func(r *Runner) {
+		r.cursorStores = stores
+	}
+}
+
+// RedisCursorStore stores the cursor in a redis key. When using the cursor client of the runner, the cursor is written
+// in the same pipeline as the batch.
+type RedisCursorStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisCursorStore(client redis.UniversalClient) *RedisCursorStore {
+	return &RedisCursorStore{client: client}
+}
+
+func (s *RedisCursorStore) Get(ctx context.Context, key string) (string, error) {
+	value, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *RedisCursorStore) Set(ctx context.Context, key string, value string) error {
+	return s.client.Set(ctx, key, value, 0).Err()
+}
+
+func (s *RedisCursorStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+func (s *RedisCursorStore) Name() string {
+	return config.CursorStoreRedis
+}
+
+// SQLCursorStore stores the cursors in a db table with cursor_key and cursor_value columns.
+type SQLCursorStore struct {
+	db          *sqlx.DB
+	namespace   string
+	selectQuery string
+	upsertQuery string
+	deleteQuery string
+}
+
+// NewSQLCursorStore returns a store using the db table, the keys are prefixed by the namespace when set (e.g. the
+// name of the redis target).
+func NewSQLCursorStore(db *sqlx.DB, dialect config.Dialect, table string, namespace string) *SQLCursorStore {
+	return &SQLCursorStore{
+		db:          db,
+		namespace:   namespace,
+		selectQuery: fmt.Sprintf("SELECT cursor_value FROM %s WHERE cursor_key = %s", table, dialect.Placeholder(1)),
+		upsertQuery: dialect.Upsert(table, "cursor_key", "cursor_value"),
+		deleteQuery: fmt.Sprintf("DELETE FROM %s WHERE cursor_key = %s", table, dialect.Placeholder(1)),
+	}
+}
+
+func (s *SQLCursorStore) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.db.GetContext(ctx, &value, s.selectQuery, namespacedKey(s.namespace, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *SQLCursorStore) Set(ctx context.Context, key string, value string) error {
+	_, err := s.db.ExecContext(ctx, s.upsertQuery, namespacedKey(s.namespace, key), value)
+	return err
+}
+
+func (s *SQLCursorStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.deleteQuery, namespacedKey(s.namespace, key))
+	return err
+}
+
+func (s *SQLCursorStore) Name() string {
+	return config.CursorStoreSQL
+}
+
+// FileCursorStore stores the cursors in a local json file, shared by the jobs. The file is replaced on each write,
+// so it's never left partially written.
+type FileCursorStore struct {
+	path      string
+	namespace string
+	mu        *sync.Mutex
+}
+
+// fileLocks serializes the writes of the stores sharing a file.
+var fileLocks sync.Map
+
+// NewFileCursorStore returns a store using the json file, the keys are prefixed by the namespace when set.
+func NewFileCursorStore(path string, namespace string) *FileCursorStore {
+	mu, _ := fileLocks.LoadOrStore(path, &sync.Mutex{})
+	return &FileCursorStore{path: path, namespace: namespace, mu: mu.(*sync.Mutex)}
+}
+
+func (s *FileCursorStore) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursors, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	return cursors[namespacedKey(s.namespace, key)], nil
+}
+
+func (s *FileCursorStore) Set(_ context.Context, key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursors, err := s.read()
+	if err != nil {
+		return err
+	}
+	cursors[namespacedKey(s.namespace, key)] = value
+	return s.write(cursors)
+}
+
+func (s *FileCursorStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursors, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := cursors[namespacedKey(s.namespace, key)]; !ok {
+		return nil
+	}
+	delete(cursors, namespacedKey(s.namespace, key))
+	return s.write(cursors)
+}
+
+func (s *FileCursorStore) Name() string {
+	return config.CursorStoreFile
+}
+
+func (s *FileCursorStore) read() (map[string]string, error) {
+	cursors := make(map[string]string)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cursors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cursors); err != nil {
+		return nil, fmt.Errorf("invalid cursor file %s: %w", s.path, err)
+	}
+	return cursors, nil
+}
+
+func (s *FileCursorStore) write(cursors map[string]string) error {
+	data, err := json.MarshalIndent(cursors, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func namespacedKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + "/" + key
+}
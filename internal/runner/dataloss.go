@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jorgebay/write-behind-cache-worker/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// redisIdentity identifies the redis process and its replication history, changing on restarts and failovers.
+type redisIdentity struct {
+	runID  string
+	replID string
+}
+
+// redisIdentity returns the identity of the redis server, or false when it can't be determined, e.g. with sharded
+// clients or servers not exposing the info sections.
+func (r *Runner) redisIdentity(ctx context.Context) (redisIdentity, bool) {
+	if r.sharded {
+		return redisIdentity{}, false
+	}
+
+	var identity redisIdentity
+	for _, section := range []string{"server", "replication"} {
+		info, err := r.redisClient.Info(ctx, section).Result()
+		if err != nil {
+			r.logger.Debug("unable to get redis info", zap.String("section", section), zap.Error(err))
+			return redisIdentity{}, false
+		}
+
+		scanner := bufio.NewScanner(strings.NewReader(info))
+		for scanner.Scan() {
+			name, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+			switch name {
+			case "run_id":
+				identity.runID = value
+			case "master_replid":
+				identity.replID = value
+			}
+		}
+	}
+	return identity, identity.runID != ""
+}
+
+// checkIdentity reports when the redis server restarted or failed over since the last poll.
+func (r *Runner) checkIdentity(ctx context.Context) {
+	identity, ok := r.redisIdentity(ctx)
+	if !ok {
+		return
+	}
+
+	previous := r.identity
+	r.identity = identity
+	switch {
+	case previous.runID == "":
+	case previous.runID != identity.runID:
+		r.logger.Warn("redis restart detected, checking for data loss",
+			zap.String("previousRunId", previous.runID), zap.String("runId", identity.runID))
+		r.metrics.add(metricRedisRestarts, 1)
+	case previous.replID != identity.replID:
+		r.logger.Warn("redis failover detected, checking for data loss",
+			zap.String("previousReplId", previous.replID), zap.String("replId", identity.replID))
+		r.metrics.add(metricRedisRestarts, 1)
+	}
+}
+
+// checkDataLoss compares the marker written along with the batches with the cursor, returning the cursor to resync
+// from when redis lost data: the marker when it's behind the cursor (e.g. a failover to a stale replica) or the
+// default value when the marker disappeared (e.g. a restart without persistence).
+func (r *Runner) checkDataLoss(ctx context.Context, cursorInfo *config.CursorInfo, cursorValue any) (any, error) {
+	r.checkIdentity(ctx)
+
+	marker, err := r.redisClient.Get(ctx, r.cfg.Redis.MarkerKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("unable to read marker key: %w", err)
+	}
+
+	if marker == "" {
+		lost, err := r.markerLost(ctx, cursorInfo, cursorValue)
+		if err != nil || !lost {
+			return cursorValue, err
+		}
+		r.logger.Warn("redis data loss detected, marker key not found, starting a full resync",
+			zap.Any("cursorValue", cursorValue), zap.Any("resyncFrom", cursorInfo.Default))
+		r.metrics.add(metricResyncs, 1)
+		return cursorInfo.Default, nil
+	}
+
+	r.markerSeen = true
+	markerValue, err := cursorInfo.ConvertFunc(marker)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert marker value to db type: %w", err)
+	}
+	comparison, err := cursorInfo.CompareFunc(markerValue, cursorValue)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compare %v and %v: %w", markerValue, cursorValue, err)
+	}
+	if comparison >= 0 {
+		return cursorValue, nil
+	}
+
+	r.logger.Warn("redis data loss detected, marker key behind the cursor, starting a resync",
+		zap.Any("cursorValue", cursorValue), zap.Any("resyncFrom", markerValue))
+	r.metrics.add(metricResyncs, 1)
+	return markerValue, nil
+}
+
+// markerLost returns true when the marker was expected: it was read before, the cursor was found while the redis
+// cursor key is missing or the marker was seeded in the cursor stores outside of redis. Otherwise, it's the first run
+// with the marker and it's seeded, so a later loss is detected with the cursor stored outside of redis.
+func (r *Runner) markerLost(ctx context.Context, cursorInfo *config.CursorInfo, cursorValue any) (bool, error) {
+	if r.markerSeen {
+		return true, nil
+	}
+
+	var redisStore bool
+	var stores []CursorStore
+	for _, store := range r.cursorStores {
+		if _, ok := store.(*RedisCursorStore); ok {
+			redisStore = true
+			continue
+		}
+		stores = append(stores, store)
+	}
+	for _, store := range stores {
+		seeded, err := store.Get(ctx, r.cfg.Redis.MarkerKey)
+		if err != nil {
+			return false, fmt.Errorf("unable to read marker from %s store: %w", store.Name(), err)
+		}
+		if seeded != "" {
+			return true, nil
+		}
+	}
+
+	exists, err := r.cursorClient.Exists(ctx, r.cfg.Redis.CursorKey).Result()
+	if err != nil {
+		return false, fmt.Errorf("unable to read cursor key: %w", err)
+	}
+	if exists == 0 && redisStore {
+		comparison, err := cursorInfo.CompareFunc(cursorValue, cursorInfo.Default)
+		if err != nil {
+			return false, fmt.Errorf("unable to compare %v and %v: %w", cursorValue, cursorInfo.Default, err)
+		}
+		if comparison != 0 {
+			return true, nil
+		}
+	}
+
+	return false, r.seedMarker(ctx, cursorInfo.Format(cursorValue), stores)
+}
+
+// seedMarker writes the marker in redis and in the cursor stores outside of redis on the first run with the marker.
+// The marker is written in redis first, so a failure seeding the stores is not detected as a loss on the next poll.
+func (r *Runner) seedMarker(ctx context.Context, marker string, stores []CursorStore) error {
+	r.logger.Info("seeding marker key", zap.String("markerKey", r.cfg.Redis.MarkerKey), zap.String("marker", marker))
+	if err := r.redisClient.Set(ctx, r.cfg.Redis.MarkerKey, marker, 0).Err(); err != nil {
+		return fmt.Errorf("unable to seed marker key: %w", err)
+	}
+	r.markerSeen = true
+
+	for _, store := range stores {
+		if err := store.Set(ctx, r.cfg.Redis.MarkerKey, marker); err != nil {
+			return fmt.Errorf("unable to seed marker in %s store: %w", store.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// nextPollDelay adapts the batch size to the latency of the last batch, when enabled, and returns the delay before
+// the next poll: none while draining full batches, bounded by the max catch-up rate. A full batch that didn't advance
+// the stored cursor (e.g. more rows share a cursor value than the batch size) would be read again, it's not drained.
+// Only the new rows count towards a full batch, the rows read again in the lookback range are not drained.
+func (r *Runner) nextPollDelay(newRows int, advanced bool, elapsed time.Duration) time.Duration {
+	full := newRows >= r.batchSize
+	if full && !advanced {
+		r.logger.Warn("cursor did not advance with a full batch, consider incrementing the batch size",
+			zap.Int("batchSize", r.batchSize))
+		full = false
+	}
+	if r.cfg.Drain.Adaptive() {
+		r.adaptBatchSize(full, elapsed)
+	}
+	if !full {
+		return r.cfg.PollDelay
+	}
+
+	if !r.cfg.Drain.Enabled {
+		r.logger.Warn("batch size reached, consider incrementing the execution rate or enabling drain",
+			zap.Int("batchSize", r.batchSize))
+		return r.cfg.PollDelay
+	}
+
+	r.metrics.add(metricDrainPolls, 1)
+	if rate := r.cfg.Drain.MaxRowsPerSecond; rate > 0 {
+		return max(0, time.Duration(float64(newRows)/float64(rate)*float64(time.Second))-elapsed)
+	}
+	return 0
+}
+
+// adaptBatchSize grows the batch size by half while full batches are processed under half the target latency and
+// halves it when over the target latency, within the min and max batch sizes.
+func (r *Runner) adaptBatchSize(full bool, elapsed time.Duration) {
+	cfg := r.cfg.Drain
+	size := r.batchSize
+	switch {
+	case elapsed > cfg.TargetLatency:
+		size = max(cfg.MinBatchSize, size/2)
+	case full && elapsed < cfg.TargetLatency/2:
+		size = min(cfg.MaxBatchSize, size+max(1, size/2))
+	}
+
+	if size != r.batchSize {
+		r.logger.Debug("adapting batch size", zap.Int("batchSize", size), zap.Duration("latency", elapsed))
+		r.batchSize = size
+	}
+}
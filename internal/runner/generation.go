@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jorgebay/write-behind-cache-worker/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// scanCount is the number of keys requested on each SCAN call, also the number of keys expired on each pipeline when
+// scheduling the deletion of a generation.
+const scanCount = 1000
+
+// errGenerationRetired is returned when the pointer was flipped from the generation of the job, which stops writing
+// so its keys expire.
+var errGenerationRetired = errors.New("generation retired")
+
+// checkGeneration registers the patterns of the keys written by the generation of the job, returning
+// errGenerationRetired once the pointer was flipped from it.
+func (r *Runner) checkGeneration(ctx context.Context) error {
+	generation := r.cfg.Redis.Generation
+	if !r.generationRegistered {
+		patterns, err := r.cfg.Redis.KeyPatterns()
+		if err != nil {
+			return err
+		}
+		value, _ := json.Marshal(patterns)
+		if err := r.redisClient.HSet(ctx, generation.PatternsKey(), generation.Name, value).Err(); err != nil {
+			return fmt.Errorf("unable to register the key patterns of the generation: %w", err)
+		}
+		r.generationRegistered = true
+	}
+
+	retired, err := r.redisClient.SIsMember(ctx, generation.RetiredKey(), generation.Name).Result()
+	if err != nil {
+		return fmt.Errorf("unable to check the retired generations: %w", err)
+	}
+	if retired {
+		return errGenerationRetired
+	}
+	return nil
+}
+
+// checkRetiredWrites expires the keys of the generation when it was retired while the batch was written, as the keys
+// of the batch could be written after the ones of the generation were expired.
+func (r *Runner) checkRetiredWrites(ctx context.Context) error {
+	generation := r.cfg.Redis.Generation
+	retired, err := r.redisClient.SIsMember(ctx, generation.RetiredKey(), generation.Name).Result()
+	if err != nil {
+		return fmt.Errorf("unable to check the retired generations: %w", err)
+	}
+	if !retired {
+		return nil
+	}
+
+	if _, err := r.expireGeneration(ctx, generation.Name); err != nil {
+		return fmt.Errorf("unable to schedule the deletion of generation %s: %w", generation.Name, err)
+	}
+	return errGenerationRetired
+}
+
+// flipGeneration atomically points the consumers to the generation of the job, scheduling the deletion of the keys of
+// the previous generation.
+func (r *Runner) flipGeneration(ctx context.Context) error {
+	generation := r.cfg.Redis.Generation
+	previous, err := r.redisClient.SetArgs(ctx, generation.PointerKey, generation.Name, redis.SetArgs{Get: true}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("unable to flip generation pointer: %w", err)
+	}
+	if previous == generation.Name {
+		return nil
+	}
+
+	r.logger.Info("generation pointer flipped", zap.String("pointerKey", generation.PointerKey),
+		zap.String("generation", generation.Name), zap.String("previous", previous))
+	if previous == "" {
+		// The keys written before the generations were enabled don't belong to a generation
+		if len(generation.LegacyPatterns) == 0 {
+			return nil
+		}
+		expired, err := r.expireKeys(ctx, generation.LegacyPatterns)
+		if err != nil {
+			return fmt.Errorf("unable to schedule the deletion of the legacy keys: %w", err)
+		}
+		r.logger.Info("scheduled the deletion of the legacy keys", zap.Strings("patterns", generation.LegacyPatterns),
+			zap.Int64("keys", expired), zap.Duration("delay", generation.DeleteDelay))
+		return nil
+	}
+
+	// The job of the previous generation stops writing before its keys are expired, otherwise it would overwrite them
+	// without expiration
+	if err := r.redisClient.SAdd(ctx, generation.RetiredKey(), previous).Err(); err != nil {
+		return fmt.Errorf("unable to retire generation %s: %w", previous, err)
+	}
+
+	expired, err := r.expireGeneration(ctx, previous)
+	if err != nil {
+		return fmt.Errorf("unable to schedule the deletion of generation %s: %w", previous, err)
+	}
+	r.logger.Info("scheduled the deletion of the previous generation", zap.String("generation", previous),
+		zap.Int64("keys", expired), zap.Duration("delay", generation.DeleteDelay))
+	return nil
+}
+
+// expireGeneration sets the expiration of the keys of the generation to the delete delay, scanning every shard for
+// the key patterns registered by the jobs of the generation.
+func (r *Runner) expireGeneration(ctx context.Context, generation string) (int64, error) {
+	value, err := r.redisClient.HGet(ctx, r.cfg.Redis.Generation.PatternsKey(), generation).Result()
+	if errors.Is(err, redis.Nil) {
+		r.logger.Warn("key patterns of the generation are not registered, its keys are not expired",
+			zap.String("generation", generation))
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var patterns []string
+	if err := json.Unmarshal([]byte(value), &patterns); err != nil {
+		return 0, fmt.Errorf("invalid key patterns of generation %s: %w", generation, err)
+	}
+	return r.expireKeys(ctx, patterns)
+}
+
+// expireKeys sets the expiration of the keys matching the patterns to the delete delay, scanning every shard.
+func (r *Runner) expireKeys(ctx context.Context, patterns []string) (int64, error) {
+	var expired atomic.Int64
+	expire := func(ctx context.Context, client *redis.Client) error {
+		pipeline := client.Pipeline()
+		for _, pattern := range patterns {
+			iter := client.Scan(ctx, 0, pattern, scanCount).Iterator()
+			for iter.Next(ctx) {
+				pipeline.PExpire(ctx, iter.Val(), r.cfg.Redis.Generation.DeleteDelay)
+				if pipeline.Len() < scanCount {
+					continue
+				}
+				expired.Add(int64(pipeline.Len()))
+				if _, err := pipeline.Exec(ctx); err != nil {
+					return err
+				}
+			}
+			if err := iter.Err(); err != nil {
+				return err
+			}
+		}
+		expired.Add(int64(pipeline.Len()))
+		_, err := pipeline.Exec(ctx)
+		return err
+	}
+
+	err := r.forEachNode(ctx, expire)
+	return expired.Load(), err
+}
+
+// seedLiveCursor sets the cursor of the live job of the generation to the checkpoint of the completed backfill, when
+// both read the same cursor and the live job didn't store a cursor yet. Otherwise, the live job starts from the
+// default value of its cursor.
+func (r *Runner) seedLiveCursor(ctx context.Context, cursorInfo *config.CursorInfo) error {
+	liveKey := r.cfg.LiveCursorKey()
+	if liveKey == "" {
+		return nil
+	}
+	for _, store := range r.cursorStores {
+		stored, err := store.Get(ctx, liveKey)
+		if err != nil {
+			return fmt.Errorf("unable to read live cursor from %s store: %w", store.Name(), err)
+		}
+		if stored != "" {
+			return nil
+		}
+	}
+
+	checkpoint, err := r.cursorValue(ctx, cursorInfo)
+	if err != nil {
+		return err
+	}
+	value := cursorInfo.Format(checkpoint)
+	r.logger.Info("seeding the live cursor from the checkpoint", zap.String("cursorKey", liveKey),
+		zap.String("cursorValue", value))
+	for _, store := range r.cursorStores {
+		if err := store.Set(ctx, liveKey, value); err != nil {
+			return fmt.Errorf("unable to seed live cursor in %s store: %w", store.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package runner
+
+import (
+	"cmp"
+	"maps"
+	"time"
+
+	"github.com/jorgebay/write-behind-cache-worker/internal/config"
+	"go.uber.org/zap"
+)
+
+// lookback tracks the trailing range of the cursor that is re-read on each poll. Rows are only written the first
+// time they are read, ids missing in the range are tracked as gaps and the safe cursor, the one stored in redis,
+// stays below the lowest gap until it's filled by a late commit or expired. In window mode, the rows are identified
+// by their cursor value and key, as they share the timestamps.
+type lookback struct {
+	cfg     config.LookbackConfig
+	logger  *zap.Logger
+	metrics *metrics
+	now     func() time.Time
+
+	// seen contains the ids above the safe cursor that were already written.
+	seen map[int64]bool
+	// gaps contains the ids missing above the safe cursor and the time they were detected.
+	gaps map[int64]time.Time
+	// seenInWindow contains the rows in the window that were already written.
+	seenInWindow map[windowRow]bool
+}
+
+// windowRow identifies a row read in the window, a row updated within the window has a new cursor value.
+type windowRow struct {
+	at  int64
+	key string
+}
+
+func newLookback(cfg config.LookbackConfig, logger *zap.Logger, metrics *metrics) *lookback {
+	if cfg.IDs == 0 && cfg.Window == 0 {
+		return nil
+	}
+
+	cfg.GapTimeout = cmp.Or(cfg.GapTimeout, config.DefaultGapTimeout)
+	return &lookback{
+		cfg:          cfg,
+		logger:       logger,
+		metrics:      metrics,
+		now:          time.Now,
+		seen:         make(map[int64]bool),
+		gaps:         make(map[int64]time.Time),
+		seenInWindow: make(map[windowRow]bool),
+	}
+}
+
+// lookbackPoll contains the changes to the lookback range of a poll, applied once the batch and the cursor are
+// stored, so the rows of a failed batch are written again on the retry.
+type lookbackPoll struct {
+	l            *lookback
+	seen         map[int64]bool
+	gaps         map[int64]time.Time
+	seenInWindow map[windowRow]bool
+
+	filled   []int64
+	detected int
+	expired  int
+}
+
+// poll returns the changes of a new poll to the lookback range.
+func (l *lookback) poll() *lookbackPoll {
+	return &lookbackPoll{
+		l:            l,
+		seen:         maps.Clone(l.seen),
+		gaps:         maps.Clone(l.gaps),
+		seenInWindow: maps.Clone(l.seenInWindow),
+	}
+}
+
+// windowed returns true when the rows are identified by their key along with the cursor value.
+func (l *lookback) windowed() bool {
+	return l.cfg.Window > 0
+}
+
+// written returns true when the row with the cursor value and key was already written in a previous poll, marking
+// it as written otherwise. The key is only used in window mode.
+func (p *lookbackPoll) written(value any, key string) bool {
+	if p.l.windowed() {
+		at, ok := value.(time.Time)
+		if !ok {
+			return false
+		}
+		row := windowRow{at: at.UnixNano(), key: key}
+		if p.seenInWindow[row] {
+			return true
+		}
+		p.seenInWindow[row] = true
+		return false
+	}
+
+	id, ok := toID(value)
+	if !ok {
+		return false
+	}
+
+	if p.seen[id] {
+		return true
+	}
+	p.seen[id] = true
+	if _, ok := p.gaps[id]; ok {
+		p.filled = append(p.filled, id)
+		delete(p.gaps, id)
+	}
+	return false
+}
+
+// safeCursor returns the cursor value to store, given the current safe cursor and the max value read.
+func (p *lookbackPoll) safeCursor(cursor, maxValue any) any {
+	if p.l.windowed() {
+		next := p.l.safeTimeCursor(cursor, maxValue)
+		if nextTime, ok := next.(time.Time); ok {
+			// Rows below the safe cursor are not read again
+			for row := range p.seenInWindow {
+				if row.at < nextTime.UnixNano() {
+					delete(p.seenInWindow, row)
+				}
+			}
+		}
+		return next
+	}
+
+	safe, ok := toID(cursor)
+	if !ok {
+		return maxValue
+	}
+	maxID, _ := toID(maxValue)
+
+	// Ids below the range are no longer tracked
+	lowest := max(safe, maxID-p.l.cfg.IDs)
+	now := p.l.now()
+	for id := lowest + 1; id < maxID; id++ {
+		if _, ok := p.gaps[id]; !ok && !p.seen[id] {
+			p.gaps[id] = now
+			p.detected++
+		}
+	}
+
+	next := maxID
+	for id, detectedAt := range p.gaps {
+		if id <= lowest || now.Sub(detectedAt) >= p.l.cfg.GapTimeout {
+			delete(p.gaps, id)
+			p.expired++
+			continue
+		}
+		next = min(next, id-1)
+	}
+
+	for id := range p.seen {
+		if id <= next {
+			delete(p.seen, id)
+		}
+	}
+
+	if _, ok := cursor.(int); ok {
+		return int(next)
+	}
+	return next
+}
+
+// commit applies the changes of the poll to the lookback range, once the batch and the cursor were stored.
+func (p *lookbackPoll) commit() {
+	l := p.l
+	l.seen = p.seen
+	l.gaps = p.gaps
+	l.seenInWindow = p.seenInWindow
+
+	for _, id := range p.filled {
+		l.logger.Info("late committed row found", zap.Int64("id", id))
+	}
+	if len(p.filled) > 0 {
+		l.metrics.add(metricGapsFilled, int64(len(p.filled)))
+	}
+	if p.detected > 0 {
+		l.logger.Info("gaps detected in the cursor range", zap.Int("gaps", p.detected))
+		l.metrics.add(metricGapsDetected, int64(p.detected))
+	}
+	if p.expired > 0 {
+		l.logger.Info("gaps expired in the cursor range", zap.Int("gaps", p.expired))
+		l.metrics.add(metricGapsExpired, int64(p.expired))
+	}
+}
+
+// safeTimeCursor returns the max value read or the time trailing the window, the earliest. Rows older than the window
+// are considered committed.
+func (l *lookback) safeTimeCursor(cursor, maxValue any) any {
+	maxTime, ok := maxValue.(time.Time)
+	if !ok {
+		return maxValue
+	}
+
+	next := minTime(maxTime, l.now().Add(-l.cfg.Window).UTC())
+	if cursorTime, ok := cursor.(time.Time); ok && next.Before(cursorTime) {
+		return cursor
+	}
+	return next
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func toID(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
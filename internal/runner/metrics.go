@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"expvar"
+)
+
+// jobsMetrics contains the counters of each job, published with expvar under "jobs".
+var jobsMetrics = expvar.NewMap("jobs")
+
+// Names of the job counters.
+const (
+	metricRowsProcessed      = "rowsProcessed"
+	metricKeysDeleted        = "keysDeleted"
+	metricExpiredKeysDeleted = "expiredKeysDeleted"
+	metricNullRowSkipped     = "nullRowsSkipped"
+	metricNullKeyDeleted     = "nullKeysDeleted"
+	metricNullRowFailed      = "nullRowsFailed"
+	metricStaleWritesSkipped = "staleWritesSkipped"
+	metricGapsDetected       = "gapsDetected"
+	metricGapsFilled         = "gapsFilled"
+	metricGapsExpired        = "gapsExpired"
+	metricRedisRestarts      = "redisRestarts"
+	metricResyncs            = "resyncs"
+	metricDrainPolls         = "drainPolls"
+)
+
+type metrics struct {
+	counters *expvar.Map
+}
+
+func newMetrics(jobName string) *metrics {
+	if counters, ok := jobsMetrics.Get(jobName).(*expvar.Map); ok {
+		return &metrics{counters: counters}
+	}
+
+	counters := new(expvar.Map).Init()
+	jobsMetrics.Set(jobName, counters)
+	return &metrics{counters: counters}
+}
+
+func (m *metrics) add(name string, delta int64) {
+	m.counters.Add(name, delta)
+}
@@ -2,7 +2,11 @@ package runner
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"strconv"
 	"time"
 
@@ -13,31 +17,113 @@ import (
 	"go.uber.org/zap"
 )
 
+// Runner polls the db and writes the rows into redis for a single job.
 type Runner struct {
-	cfg         *config.Config
+	cfg         *config.JobConfig
 	db          *sqlx.DB
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 	logger      *zap.Logger
+	metrics     *metrics
+
+	// cursorClient is the client used to read and write the cursor and timestamp keys, the redis client by default.
+	cursorClient redis.UniversalClient
+
+	// sharded is set when the batches are split per shard by the client (cluster or ring), where the cursor is
+	// written once all the shards succeeded.
+	sharded bool
+
+	// batchSize is the effective batch size, the configured one unless the adaptive batch size is enabled.
+	batchSize int
+
+	// lookback tracks the trailing range of the cursor when enabled.
+	lookback *lookback
+
+	// cursorStores are the stores of the cursor, a redis store on the cursor client by default.
+	cursorStores []CursorStore
+
+	// identity is the last known identity of the redis server and markerSeen is set once the marker key was read,
+	// used to detect data loss.
+	identity   redisIdentity
+	markerSeen bool
+
+	// generationRegistered is set once the key patterns of the generation were registered.
+	generationRegistered bool
 }
 
 type ctxKey string
 
-func NewRunner(cfg *config.Config, db *sqlx.DB, redisClient *redis.Client, logger *zap.Logger) *Runner {
-	return &Runner{
-		cfg:         cfg,
-		db:          db,
-		redisClient: redisClient,
-		logger:      logger,
+// Option configures optional settings of the Runner.
+type Option func(r *Runner)
+
+// WithCursorClient sets the client used to store the cursor, e.g. a designated node of a ring.
+func WithCursorClient(client redis.UniversalClient) Option {
+	return func(r *Runner) {
+		r.cursorClient = client
 	}
 }
 
+func NewRunner(
+	cfg *config.JobConfig,
+	db *sqlx.DB,
+	redisClient redis.UniversalClient,
+	logger *zap.Logger,
+	opts ...Option,
+) *Runner {
+	r := &Runner{
+		cfg:          cfg,
+		db:           db,
+		redisClient:  redisClient,
+		logger:       logger.With(zap.String("job", cfg.Name)),
+		metrics:      newMetrics(cfg.Name),
+		cursorClient: redisClient,
+	}
+	r.lookback = newLookback(cfg.DB.Cursor.Lookback, r.logger, r.metrics)
+	r.batchSize = cfg.BatchSize
+	if cfg.Drain.Adaptive() {
+		r.batchSize = min(max(cfg.BatchSize, cfg.Drain.MinBatchSize), cfg.Drain.MaxBatchSize)
+	}
+
+	switch redisClient.(type) {
+	case *redis.ClusterClient, *redis.Ring:
+		r.sharded = true
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.cursorStores == nil {
+		r.cursorStores = []CursorStore{NewRedisCursorStore(r.cursorClient)}
+	}
+	return r
+}
+
+// cursorValue returns the lowest cursor of the stores, ignoring the ones without a cursor, or the default value.
 func (r *Runner) cursorValue(ctx context.Context, cursorInfo *config.CursorInfo) (result any, err error) {
-	redisCursorDefaultValue := r.redisClient.Get(ctx, r.cfg.Redis.CursorKey)
-	if redisCursorDefaultValue.Val() != "" {
-		result, err = cursorInfo.ConvertFunc(redisCursorDefaultValue.Val())
+	for _, store := range r.cursorStores {
+		stored, err := store.Get(ctx, r.cfg.Redis.CursorKey)
 		if err != nil {
-			return nil, fmt.Errorf("unable to convert redis cursor value to db type: %w", err)
+			return nil, fmt.Errorf("unable to read cursor from %s store: %w", store.Name(), err)
+		}
+		if stored == "" {
+			continue
 		}
+
+		value, err := cursorInfo.ConvertFunc(stored)
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert %s cursor value to db type: %w", store.Name(), err)
+		}
+		if result != nil {
+			comparison, err := cursorInfo.CompareFunc(value, result)
+			if err != nil {
+				return nil, fmt.Errorf("unable to compare %v and %v: %w", value, result, err)
+			}
+			if comparison >= 0 {
+				continue
+			}
+			r.logger.Info("using the lower cursor of a store",
+				zap.String("store", store.Name()), zap.Any("cursorValue", value))
+		}
+		result = value
 	}
 	if result == nil {
 		result = cursorInfo.Default
@@ -52,33 +138,33 @@ func (r *Runner) Run(ctx context.Context) error {
 		return err
 	}
 
-	keyFn, err := r.cfg.Redis.KeyFn(r.logger)
-	if err != nil {
-		return err
-	}
-	valueFn, err := r.cfg.Redis.ValueFn(r.logger)
+	fns, err := r.rowFuncs()
 	if err != nil {
 		return err
 	}
 	shouldStop := shouldStopFn(ctx)
-	backoffer := backoff.NewExponentialBackOff()
+	// The job retries until it's stopped, without affecting the other jobs of the worker
+	backoffer := backoff.NewExponentialBackOff(backoff.WithMaxElapsedTime(0))
 	var lastErr error
 
 	for i := uint64(0); !shouldStop(i); i++ {
-		err := r.runOnce(ctx, cursorInfo, keyFn, valueFn)
-		pollDelay := r.cfg.PollDelay
+		start := time.Now()
+		_, newRows, advanced, err := r.runOnce(ctx, cursorInfo, fns)
+		if errors.Is(err, errGenerationRetired) {
+			r.logger.Warn("the pointer was flipped from the generation of the job, stopping",
+				zap.String("generation", r.cfg.Redis.Generation.Name))
+			return nil
+		}
+		pollDelay := r.nextPollDelay(newRows, advanced, time.Since(start))
 		if err != nil {
-			if i == 0 {
-				// Don't apply backoff on the first iteration, surface the error immediately
+			if i == 0 && !transientError(err) {
+				// Don't apply backoff on the first iteration, surface the error immediately, e.g. an invalid query
 				return err
 			}
 
 			pollDelay = backoffer.NextBackOff()
-			if pollDelay == backoff.Stop {
-				return fmt.Errorf("backoff stop: %w", err)
-			}
-
-			r.logger.Info("Error encounter during run, retrying after delay", zap.Duration("delay", pollDelay))
+			r.logger.Info("Error encounter during run, retrying after delay", zap.Duration("delay", pollDelay),
+				zap.Error(err))
 			lastErr = err
 		} else if lastErr != nil {
 			r.logger.Info("Error resolved, polling at regular interval")
@@ -94,87 +180,241 @@ func (r *Runner) Run(ctx context.Context) error {
 		}
 	}
 
-	return nil
+	// Only reached when the iterations are limited, surfacing the error of the last one
+	return lastErr
 }
 
+// transientError returns true when the error is caused by the connection to the db or redis, which is retried even
+// on the first poll, as opposed to an invalid query or template.
+func transientError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, driver.ErrBadConn) || errors.Is(err, redis.ErrClosed)
+}
+
+// runOnce reads a batch from the cursor and writes it into redis, returning the number of rows read, the number of
+// new rows (excluding the rows read again in the lookback range) and whether the stored cursor advanced.
 func (r *Runner) runOnce(
 	ctx context.Context,
 	cursorInfo *config.CursorInfo,
-	keyFn config.KeyFunc,
-	valueFn config.ValueFunc,
-) error {
+	fns *rowFuncs,
+) (int, int, bool, error) {
+	if r.cfg.Redis.Generation.Name != "" {
+		if err := r.checkGeneration(ctx); err != nil {
+			return 0, 0, false, err
+		}
+	}
+
 	cursorValue, err := r.cursorValue(ctx, cursorInfo)
 	if err != nil {
-		return err
+		return 0, 0, false, err
+	}
+	if r.cfg.Redis.MarkerKey != "" {
+		if cursorValue, err = r.checkDataLoss(ctx, cursorInfo, cursorValue); err != nil {
+			return 0, 0, false, err
+		}
 	}
 
-	r.logger.Debug("running db query", zap.Any("cursorValue", cursorValue))
-	rows, err := r.db.QueryxContext(ctx, r.cfg.DB.SelectQuery, cursorValue) //nolint:sqlclosecheck
-	if err != nil {
-		r.logger.Error("unable to query db", zap.Error(err), zap.String("query", r.cfg.DB.SelectQuery))
-		return err
+	var queryCursor any = cursorValue
+	if cursorInfo.Snapshot() {
+		xmin, err := r.snapshotXmin(ctx)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		queryCursor = config.SnapshotCursor{Cursor: cursorValue, Xmin: xmin}
 	}
 
+	rows, decode, err := r.query(ctx, queryCursor)
+	if err != nil {
+		return 0, 0, false, err
+	}
 	defer rows.Close()
 
 	totalRows := 0
-	redisPipeline := r.redisClient.Pipeline()
+	readRows := 0
+	maxCursorValue := cursorValue
+	redisPipeline := &batch{Pipeliner: r.pipeline()}
+	var lookbackPoll *lookbackPoll
+	if r.lookback != nil {
+		lookbackPoll = r.lookback.poll()
+	}
 
 	for rows.Next() {
 		m := make(map[string]any)
 		err := rows.MapScan(m)
 		if err != nil {
-			return fmt.Errorf("unable to map scan: %w", err)
+			return 0, 0, false, fmt.Errorf("unable to map scan: %w", err)
+		}
+		if err := decode(m); err != nil {
+			return 0, 0, false, err
 		}
+		readRows++
 
-		nextCursorValue := m[cursorInfo.Column]
-		if nextCursorValue == nil {
-			return fmt.Errorf("cursor column '%s' is nil or does not exists", cursorInfo.Column)
+		rowCursorValue, err := cursorInfo.Value(m)
+		if err != nil {
+			return 0, 0, false, err
 		}
 
-		comparison, err := cursorInfo.CompareFunc(cursorValue, nextCursorValue)
+		comparison, err := cursorInfo.CompareFunc(maxCursorValue, rowCursorValue)
 		if err != nil {
-			return fmt.Errorf("unable to compare %v and %v: %w", cursorValue, nextCursorValue, err)
+			return 0, 0, false, fmt.Errorf("unable to compare %v and %v: %w", maxCursorValue, rowCursorValue, err)
 		}
 
 		if comparison < 0 {
-			cursorValue = nextCursorValue
+			maxCursorValue = rowCursorValue
+		}
+
+		if lookbackPoll != nil {
+			key := ""
+			if r.lookback.windowed() {
+				// The rows without a key are skipped or fail when written
+				key, _ = fns.key(m)
+			}
+			if lookbackPoll.written(rowCursorValue, key) {
+				continue
+			}
 		}
 
-		key := keyFn(m)
-		value := valueFn(m)
-		r.logger.Debug("setting key", zap.String("key", key), zap.Any("value", value))
-		if err := redisPipeline.Set(ctx, key, value, 0).Err(); err != nil {
-			return fmt.Errorf("unable to set key '%s': %w", key, err)
+		if err := r.write(ctx, redisPipeline, fns, m); err != nil {
+			return 0, 0, false, err
 		}
 		totalRows++
 	}
 
+	// In cluster and ring modes, the batch is split per hash slot or shard and each one is executed independently,
+	// the cursor is written once all of them succeeded. Transactions are not rolled back when a command fails, the
+	// cursor is written after the transaction once every command succeeded, a batch can be written again when the
+	// worker stops in between (at-least-once)
+	separateCursor := r.sharded || r.cursorClient != r.redisClient || r.cfg.Redis.Transactional
+	cursorPipeline := redisPipeline.Pipeliner
+	if separateCursor {
+		cursorPipeline = r.cursorClient.Pipeline()
+	}
+
 	pipelineHasChanges := false
 	if r.cfg.Redis.TimestampKey != "" {
-		redisPipeline.Set(ctx, r.cfg.Redis.TimestampKey, strconv.FormatInt(time.Now().Unix(), 10), 0)
+		cursorPipeline.Set(ctx, r.cfg.Redis.TimestampKey, strconv.FormatInt(time.Now().Unix(), 10), 0)
 		pipelineHasChanges = true
 	}
 
+	nextCursorValue := maxCursorValue
+	switch snapshot := queryCursor.(type) {
+	case config.SnapshotCursor:
+		nextCursorValue = r.snapshotCursor(snapshot, maxCursorValue, readRows)
+	default:
+		if lookbackPoll != nil {
+			nextCursorValue = lookbackPoll.safeCursor(cursorValue, maxCursorValue)
+		}
+	}
+	comparison, err := cursorInfo.CompareFunc(cursorValue, nextCursorValue)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("unable to compare %v and %v: %w", cursorValue, nextCursorValue, err)
+	}
+
 	if totalRows > 0 {
 		r.logger.Info("processed rows", zap.Int("rows", totalRows))
-		if totalRows == r.cfg.BatchSize {
-			r.logger.Warn("batch size reached, consider incrementing the execution rate",
-				zap.Int("batchSize", r.cfg.BatchSize))
-		}
+		r.metrics.add(metricRowsProcessed, int64(totalRows))
+	}
 
-		r.logger.Debug("setting cursor", zap.Any("cursorValue", cursorValue))
-		redisPipeline.Set(ctx, r.cfg.Redis.CursorKey, fmt.Sprint(cursorValue), 0)
+	var (
+		storedCursor string
+		cursorStores []CursorStore
+	)
+	if totalRows > 0 || comparison != 0 {
+		r.logger.Debug("setting cursor", zap.Any("cursorValue", nextCursorValue))
+		storedCursor = cursorInfo.Format(nextCursorValue)
+		for _, store := range r.cursorStores {
+			if s, ok := store.(*RedisCursorStore); ok && s.client == r.cursorClient {
+				cursorPipeline.Set(ctx, r.cfg.Redis.CursorKey, storedCursor, 0)
+				continue
+			}
+			// The other stores are written once the batch succeeded
+			cursorStores = append(cursorStores, store)
+		}
+		if r.cfg.Redis.MarkerKey != "" {
+			redisPipeline.Set(ctx, r.cfg.Redis.MarkerKey, storedCursor, 0)
+		}
 		pipelineHasChanges = true
 	}
 
 	if pipelineHasChanges {
-		if _, err := redisPipeline.Exec(ctx); err != nil {
-			return fmt.Errorf("unable to execute pipeline: %w", err)
+		if err := r.loadVersionScript(ctx, redisPipeline); err != nil {
+			return 0, 0, false, err
+		}
+		if redisPipeline.Len() > 0 {
+			if _, err := redisPipeline.Exec(ctx); err != nil {
+				return 0, 0, false, fmt.Errorf("unable to execute pipeline: %w", err)
+			}
+			r.checkGuardedWrites(redisPipeline)
+			r.markerSeen = r.markerSeen || r.cfg.Redis.MarkerKey != ""
+			if r.cfg.Redis.Generation.Name != "" && totalRows > 0 {
+				if err := r.checkRetiredWrites(ctx); err != nil {
+					return 0, 0, false, err
+				}
+			}
 		}
+		if separateCursor {
+			if _, err := cursorPipeline.Exec(ctx); err != nil {
+				return 0, 0, false, fmt.Errorf("unable to set cursor: %w", err)
+			}
+		}
+		for _, store := range cursorStores {
+			if err := store.Set(ctx, r.cfg.Redis.CursorKey, storedCursor); err != nil {
+				return 0, 0, false, fmt.Errorf("unable to set cursor in %s store: %w", store.Name(), err)
+			}
+		}
+	}
+
+	if lookbackPoll != nil {
+		lookbackPoll.commit()
+	}
+	return readRows, totalRows, comparison < 0, nil
+}
+
+// query runs the select query from the cursor, returning the rows and the decoder of their values.
+func (r *Runner) query(ctx context.Context, queryCursor any) (*sqlx.Rows, config.RowDecodeFunc, error) {
+	r.logger.Debug("running db query", zap.Any("cursorValue", queryCursor))
+	args := r.cfg.QueryArgsWithBatchSize(queryCursor, r.batchSize)
+	rows, err := r.db.QueryxContext(ctx, r.cfg.DB.SelectQuery, args...) //nolint:sqlclosecheck
+	if err != nil {
+		r.logger.Error("unable to query db", zap.Error(err), zap.String("query", r.cfg.DB.SelectQuery))
+		return nil, nil, err
 	}
 
-	return nil
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, nil, fmt.Errorf("unable to get column types: %w", err)
+	}
+	decode, err := r.cfg.DB.RowDecoder(columnTypes)
+	if err != nil {
+		rows.Close()
+		return nil, nil, err
+	}
+	return rows, decode, nil
+}
+
+// pipeline returns the pipeline used to write a batch, when transactional the commands are wrapped in MULTI/EXEC.
+func (r *Runner) pipeline() redis.Pipeliner {
+	if r.cfg.Redis.Transactional {
+		return r.redisClient.TxPipeline()
+	}
+
+	return r.redisClient.Pipeline()
+}
+
+// forEachNode calls the function with the client of each node of the redis deployment: the masters of a cluster, the
+// shards of a ring or the single server.
+func (r *Runner) forEachNode(ctx context.Context, fn func(ctx context.Context, client *redis.Client) error) error {
+	switch client := r.redisClient.(type) {
+	case *redis.ClusterClient:
+		return client.ForEachMaster(ctx, fn)
+	case *redis.Ring:
+		return client.ForEachShard(ctx, fn)
+	case *redis.Client:
+		return fn(ctx, client)
+	}
+	return fmt.Errorf("unsupported redis client %T", r.redisClient)
 }
 
 func shouldStopFn(ctx context.Context) func(uint64) bool {
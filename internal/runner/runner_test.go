@@ -1,18 +1,29 @@
 package runner
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/jmoiron/sqlx"
 	"github.com/jorgebay/write-behind-cache-worker/internal/config"
 	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/redis/go-redis/v9"
@@ -28,6 +39,7 @@ var (
 	runner      *Runner
 	redisClient *redis.Client
 	db          *sqlx.DB
+	logger      *zap.Logger
 )
 
 var _ = Describe("Runner", func() {
@@ -77,10 +89,1162 @@ var _ = Describe("Runner", func() {
 				expectRedisValues(ctx, "my-worker:2000:key", "4")
 				expectRedisValues(ctx, "my-worker:3000:key", "6")
 			})
+
+			It("should set the expiration of the keys", func() {
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key")
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+
+				cfg := *runner.cfg
+				cfg.Redis.TTL = "1h"
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectRedisValues(ctx, "my-worker:1000:key", "2")
+				Expect(redisClient.TTL(ctx, "my-worker:1000:key").Val()).To(BeNumerically("~", time.Hour, time.Minute))
+				Expect(redisClient.TTL(ctx, "my-worker:2000:key").Val()).To(BeNumerically("~", time.Hour, time.Minute))
+			})
+
+			It("should delete the keys of the expired rows", func() {
+				redisClient.Set(ctx, "my-worker:2000:key", "previous", 0)
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = `SELECT MAX(id) as id, partition_key, 2000 - partition_key AS ttl FROM sample_table
+					WHERE id > $1 GROUP BY partition_key`
+				cfg.Redis.TTL = "${ttl}"
+				r := NewRunner(&cfg, db, redisClient, logger)
+				deleted := r.metrics.counters.Get(metricExpiredKeysDeleted)
+
+				Expect(r.Run(ctx)).To(Succeed())
+
+				expectRedisValues(ctx, "my-worker:1000:key", "2")
+				Expect(redisClient.TTL(ctx, "my-worker:1000:key").Val()).To(BeNumerically("~", 1000*time.Second, time.Minute))
+				expectRedisValuesNotFound(ctx, "my-worker:2000:key")
+				expectRedisValues(ctx, runner.cfg.Redis.CursorKey, "3")
+				Expect(counterValue(r.metrics.counters.Get(metricExpiredKeysDeleted))).To(Equal(counterValue(deleted) + 1))
+			})
+
+			It("should write the rows as hashes", func() {
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key")
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+
+				cfg := *runner.cfg
+				cfg.Redis.Mode = config.ModeHash
+				cfg.Redis.Fields = map[string]string{"id": "latest_id"}
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(redisClient.HGetAll(ctx, "my-worker:1000:key").Val()).To(Equal(map[string]string{"latest_id": "2"}))
+				Expect(redisClient.HGetAll(ctx, "my-worker:2000:key").Val()).To(Equal(map[string]string{"latest_id": "3"}))
+			})
+
+			It("should write the rows as json", func() {
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key")
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+
+				cfg := *runner.cfg
+				cfg.Redis.ValueFormat = config.ValueFormatJSON
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(redisClient.Get(ctx, "my-worker:1000:key").Val()).To(MatchJSON(`{"id":2,"partition_key":1000}`))
+				Expect(redisClient.Get(ctx, "my-worker:2000:key").Val()).To(MatchJSON(`{"id":3,"partition_key":2000}`))
+			})
+
+			It("should not advance the cursor when a transactional batch fails", func() {
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key")
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+
+				failingClient := redis.NewClient(redisClient.Options())
+				defer failingClient.Close()
+				failingClient.AddHook(failingKeyHook{key: "my-worker:2000:key"})
+
+				cfg := *runner.cfg
+				cfg.Redis.Transactional = true
+				r := NewRunner(&cfg, db, failingClient, logger)
+
+				err := r.Run(context.WithValue(ctx, ctxKey("test-max-iterations"), 1))
+				Expect(err).To(HaveOccurred())
+
+				expectRedisValues(ctx, runner.cfg.Redis.CursorKey, "0")
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key", "my-worker:2000:key")
+			})
+
+			It("should not advance the cursor when a command of a transactional batch fails", func() {
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key")
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+				// HSET fails with WRONGTYPE within the transaction, the other commands are applied
+				redisClient.Set(ctx, "my-worker:2000:key", "string", 0)
+
+				cfg := *runner.cfg
+				cfg.Redis.Transactional = true
+				cfg.Redis.Mode = config.ModeHash
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err := r.Run(context.WithValue(ctx, ctxKey("test-max-iterations"), 1))
+				Expect(err).To(MatchError(ContainSubstring("WRONGTYPE")))
+				expectRedisValues(ctx, runner.cfg.Redis.CursorKey, "0")
+
+				redisClient.Del(ctx, "my-worker:2000:key")
+				Expect(r.Run(context.WithValue(ctx, ctxKey("test-max-iterations"), 1))).To(Succeed())
+				Expect(redisClient.HGetAll(ctx, "my-worker:2000:key").Val()).To(HaveKeyWithValue("id", "3"))
+				expectRedisValues(ctx, runner.cfg.Redis.CursorKey, "3")
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key")
+			})
+
+			It("should bind the named placeholders of the query", func() {
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key")
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = `SELECT MAX(id) as id, partition_key FROM sample_table
+					WHERE id > :cursor AND partition_key > :min_partition_key
+					GROUP BY partition_key ORDER BY partition_key LIMIT :batch_size -- trailing comment`
+				cfg.DB.Params = map[string]string{"min_partition_key": "1500"}
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg.BindSelectQuery(dialect)).To(Succeed())
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err = r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key")
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				expectRedisValues(ctx, runner.cfg.Redis.CursorKey, "3")
+			})
+
+			It("should not overwrite keys with newer versions", func() {
+				clearRedisValues(ctx, "my-worker:2000:key", "my-worker:2000:key:version")
+				redisClient.Set(ctx, "my-worker:1000:key", "newer", 0)
+				redisClient.Set(ctx, "my-worker:1000:key:version", "10", 0)
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+
+				cfg := *runner.cfg
+				cfg.Redis.VersionColumn = "id"
+				r := NewRunner(&cfg, db, redisClient, logger)
+				skipped := r.metrics.counters.Get(metricStaleWritesSkipped)
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectRedisValues(ctx, "my-worker:1000:key", "newer")
+				expectRedisValues(ctx, "my-worker:1000:key:version", "10")
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				expectRedisValues(ctx, "my-worker:2000:key:version", "3")
+				Expect(counterValue(r.metrics.counters.Get(metricStaleWritesSkipped))).To(Equal(counterValue(skipped) + 1))
+			})
+
+			It("should compare the versions above 2^53 exactly", func() {
+				redisClient.Set(ctx, "my-worker:1000:key:version", "9007199254740992", 0)
+				redisClient.Set(ctx, "my-worker:2000:key", "newer", 0)
+				redisClient.Set(ctx, "my-worker:2000:key:version", "9007199254740994", 0)
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "0", 0)
+				DeferCleanup(func() {
+					clearRedisValues(ctx, "my-worker:1000:key:version", "my-worker:2000:key:version")
+				})
+
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = `SELECT MAX(id) as id, partition_key, 9007199254740993 AS version FROM sample_table
+					WHERE id > $1 GROUP BY partition_key`
+				cfg.Redis.VersionColumn = "version"
+				Expect(NewRunner(&cfg, db, redisClient, logger).Run(ctx)).To(Succeed())
+
+				expectRedisValues(ctx, "my-worker:1000:key", "2")
+				expectRedisValues(ctx, "my-worker:1000:key:version", "9007199254740993")
+				expectRedisValues(ctx, "my-worker:2000:key", "newer")
+				expectRedisValues(ctx, "my-worker:2000:key:version", "9007199254740994")
+			})
+
+			It("should compare the versions as decimal numbers", func() {
+				tests := []struct {
+					current string
+					version string
+					applied int64
+				}{
+					{"9", "10", 1},
+					{"10", "9", 0},
+					{"10", "10", 0},
+					{"1.5", "1.25", 0},
+					{"1.25", "1.5", 1},
+					{"0.5", "1", 1},
+					{"-2", "-10", 0},
+					{"-10", "-2", 1},
+					{"-1", "0", 1},
+					{"007", "8", 1},
+					{"12345678901234567890", "12345678901234567891", 1},
+				}
+				DeferCleanup(func() { clearRedisValues(ctx, "version:key", "version:key:version") })
+
+				for _, test := range tests {
+					redisClient.Set(ctx, "version:key:version", test.current, 0)
+					applied, err := versionScript.Run(ctx, redisClient, []string{"version:key", "version:key:version"},
+						test.version, commandSet, 0, 0, "value").Int64()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(applied).To(Equal(test.applied), "version %s over %s", test.version, test.current)
+				}
+			})
+		})
+
+		Context("with errors", func() {
+			It("should return the error of the first poll when the query is invalid", func() {
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = "SELECT id, partition_key FROM missing_table WHERE id > $1"
+				// Fails when the error is retried
+				timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				Expect(NewRunner(&cfg, db, redisClient, logger).Run(timeoutCtx)).To(MatchError(ContainSubstring("missing_table")))
+				Expect(timeoutCtx.Err()).NotTo(HaveOccurred())
+			})
+
+			It("should retry the connection errors of the first poll", func() {
+				unreachable := redis.NewClient(&redis.Options{Addr: "localhost:1", MaxRetries: -1})
+				defer unreachable.Close()
+				timeoutCtx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+				defer cancel()
+				Expect(NewRunner(runner.cfg, db, unreachable, logger).Run(timeoutCtx)).To(Succeed())
+				Expect(timeoutCtx.Err()).To(HaveOccurred())
+			})
+		})
+
+		Context("with multiple redis targets", func() {
+			BeforeEach(func() {
+				deleteFrom("sample_table", 4)
+			})
+
+			It("should track the cursor of each target", func() {
+				opts := *redisClient.Options()
+				opts.DB = 1
+				lagging := redis.NewClient(&opts)
+				defer lagging.Close()
+
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key")
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "2", 0)
+				lagging.Del(ctx, "my-worker:1000:key", "my-worker:2000:key", runner.cfg.Redis.CursorKey)
+
+				for _, client := range []*redis.Client{redisClient, lagging} {
+					err := NewRunner(runner.cfg, db, client, logger).Run(ctx)
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key")
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				Expect(lagging.Get(ctx, "my-worker:1000:key").Val()).To(Equal("2"))
+				Expect(lagging.Get(ctx, "my-worker:2000:key").Val()).To(Equal("3"))
+				Expect(lagging.Get(ctx, runner.cfg.Redis.CursorKey).Val()).To(Equal("3"))
+			})
+
+			It("should keep advancing the cursor of a target while another one is unreachable", func() {
+				unreachable := redis.NewClient(&redis.Options{Addr: "localhost:1", MaxRetries: -1})
+				defer unreachable.Close()
+				cfg := *runner.cfg
+				cfg.PollDelay = 50 * time.Millisecond
+				redisClient.Set(ctx, cfg.Redis.CursorKey, "3", 0)
+
+				runCtx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				var wg sync.WaitGroup
+				errs := make([]error, 2)
+				for i, client := range []*redis.Client{redisClient, unreachable} {
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+						errs[i] = NewRunner(&cfg, db, client, logger).Run(runCtx)
+					}()
+				}
+
+				insert("sample_table", 6, 3000)
+				DeferCleanup(deleteFrom, "sample_table", 4)
+				Eventually(func() string { return redisClient.Get(ctx, cfg.Redis.CursorKey).Val() }).
+					WithTimeout(5 * time.Second).Should(Equal("6"))
+				cancel()
+				wg.Wait()
+				Expect(errs).To(Equal([]error{nil, nil}))
+			})
+		})
+
+		Context("with cursor stores", func() {
+			BeforeEach(func() {
+				deleteFrom("sample_table", 4)
+				_, err := db.Exec("DELETE FROM worker_cursors")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("should write the cursor to every store and use the lowest one", func() {
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				sqlStore := NewSQLCursorStore(db, dialect, "worker_cursors", "")
+				fileStore := NewFileCursorStore(filepath.Join(GinkgoT().TempDir(), "cursors.json"), "eu")
+				redisStore := NewRedisCursorStore(redisClient)
+				key := runner.cfg.Redis.CursorKey
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key", key)
+
+				r := NewRunner(runner.cfg, db, redisClient, logger, WithCursorStores(redisStore, sqlStore, fileStore))
+				Expect(r.Run(ctx)).To(Succeed())
+				for _, store := range []CursorStore{redisStore, sqlStore, fileStore} {
+					Expect(store.Get(ctx, key)).To(Equal("3"), "%s store", store.Name())
+				}
+
+				// The redis data was lost and the file store is behind
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key", key)
+				Expect(fileStore.Set(ctx, key, "2")).To(Succeed())
+				Expect(r.Run(ctx)).To(Succeed())
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key")
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				Expect(fileStore.Get(ctx, key)).To(Equal("3"))
+			})
+
+			It("should not write the cursor to redis when not a store", func() {
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				sqlStore := NewSQLCursorStore(db, dialect, "worker_cursors", "eu")
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key", runner.cfg.Redis.CursorKey)
+
+				r := NewRunner(runner.cfg, db, redisClient, logger, WithCursorStores(sqlStore))
+				Expect(r.Run(ctx)).To(Succeed())
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				expectRedisValuesNotFound(ctx, runner.cfg.Redis.CursorKey)
+				Expect(sqlStore.Get(ctx, runner.cfg.Redis.CursorKey)).To(Equal("3"))
+
+				var storedKey string
+				Expect(db.Get(&storedKey, "SELECT cursor_key FROM worker_cursors")).To(Succeed())
+				Expect(storedKey).To(Equal("eu/" + runner.cfg.Redis.CursorKey))
+			})
+		})
+
+		Context("with backfill", func() {
+			var cfg config.JobConfig
+
+			BeforeEach(func() {
+				deleteFrom("sample_table", 4)
+				cfg = *runner.cfg
+				cfg.DB.Backfill = config.BackfillConfig{
+					SelectQuery:   "SELECT id, partition_key FROM sample_table WHERE id > $1 ORDER BY id LIMIT 1",
+					Cursor:        config.CursorConfig{Column: "id", Type: "int64", Default: "0"},
+					BatchSize:     1,
+					RowsPerSecond: 1000,
+					CheckpointKey: "my-worker:backfill",
+				}
+				cfg = cfg.BackfillJob()
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key", cfg.Redis.CursorKey)
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "3", 0)
+			})
+
+			It("should walk the table in chunks without moving the cursor", func() {
+				r := NewRunner(&cfg, db, redisClient, logger)
+				Expect(r.Backfill(ctx)).To(Succeed())
+
+				expectRedisValues(ctx, "my-worker:1000:key", "2")
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				expectRedisValues(ctx, runner.cfg.Redis.CursorKey, "3")
+				expectRedisValuesNotFound(ctx, cfg.Redis.CursorKey)
+			})
+
+			It("should resume from the checkpoint", func() {
+				redisClient.Set(ctx, cfg.Redis.CursorKey, "2", 0)
+
+				r := NewRunner(&cfg, db, redisClient, logger)
+				Expect(r.Backfill(ctx)).To(Succeed())
+
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key", cfg.Redis.CursorKey)
+			})
+
+			It("should flip the generation once completed", func() {
+				cfg.Redis.Generation = config.GenerationConfig{Name: "g2", PointerKey: "my-worker:generation",
+					DeleteDelay: time.Hour}
+				clearRedisValues(ctx, "g2:my-worker:1000:key", "g2:my-worker:2000:key", "my-worker:generation:patterns",
+					"my-worker:generation:retired")
+				redisClient.Set(ctx, "my-worker:generation", "g1", 0)
+				redisClient.HSet(ctx, "my-worker:generation:patterns", "g1", `["g1:my-worker:*:key"]`)
+				redisClient.Set(ctx, "g1:my-worker:1000:key", "1", 0)
+				redisClient.Set(ctx, "g1:other-worker:1000:key", "1", 0)
+				redisClient.Set(ctx, "g10:my-worker:1000:key", "1", 0)
+
+				r := NewRunner(&cfg, db, redisClient, logger)
+				Expect(r.Backfill(ctx)).To(Succeed())
+
+				expectRedisValues(ctx, "g2:my-worker:1000:key", "2")
+				expectRedisValues(ctx, "g2:my-worker:2000:key", "3")
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key")
+				expectRedisValues(ctx, "my-worker:generation", "g2")
+				Expect(redisClient.SMembers(ctx, "my-worker:generation:retired").Val()).To(Equal([]string{"g1"}))
+				Expect(redisClient.HGet(ctx, "my-worker:generation:patterns", "g2").Val()).
+					To(Equal(`["g2:my-worker:*:key"]`))
+				Expect(redisClient.TTL(ctx, "g1:my-worker:1000:key").Val()).To(BeNumerically("~", time.Hour, time.Minute))
+				Expect(redisClient.TTL(ctx, "g1:other-worker:1000:key").Val()).To(Equal(time.Duration(-1)))
+				Expect(redisClient.TTL(ctx, "g10:my-worker:1000:key").Val()).To(Equal(time.Duration(-1)))
+				Expect(redisClient.TTL(ctx, "g2:my-worker:1000:key").Val()).To(Equal(time.Duration(-1)))
+			})
+
+			It("should expire the legacy keys and seed the live cursor on the first flip", func() {
+				cfg.Redis.Generation = config.GenerationConfig{Name: "g2", PointerKey: "my-worker:generation",
+					DeleteDelay: time.Hour, LegacyPatterns: []string{"my-worker:*:key"}}
+				clearRedisValues(ctx, "g2:my-worker:1000:key", "g2:my-worker:2000:key", "my-worker:generation",
+					"my-worker:generation:patterns", "my-worker:generation:retired", runner.cfg.Redis.CursorKey)
+				redisClient.Set(ctx, "my-worker:1000:key", "1", 0)
+				redisClient.Set(ctx, "other-worker:1000:key", "1", 0)
+				DeferCleanup(func() { clearRedisValues(ctx, "other-worker:1000:key") })
+
+				r := NewRunner(&cfg, db, redisClient, logger)
+				Expect(r.Backfill(ctx)).To(Succeed())
+
+				expectRedisValues(ctx, "my-worker:generation", "g2")
+				expectRedisValues(ctx, runner.cfg.Redis.CursorKey, "3")
+				expectRedisValuesNotFound(ctx, cfg.Redis.CursorKey)
+				Expect(redisClient.TTL(ctx, "my-worker:1000:key").Val()).To(BeNumerically("~", time.Hour, time.Minute))
+				Expect(redisClient.TTL(ctx, "other-worker:1000:key").Val()).To(Equal(time.Duration(-1)))
+				Expect(redisClient.TTL(ctx, "g2:my-worker:1000:key").Val()).To(Equal(time.Duration(-1)))
+			})
+
+			It("should not seed the live cursor when reading a different cursor", func() {
+				cfg = *runner.cfg
+				cfg.DB.Backfill = config.BackfillConfig{
+					SelectQuery:   "SELECT id, partition_key FROM sample_table WHERE partition_key > $1 ORDER BY id",
+					Cursor:        config.CursorConfig{Column: "partition_key", Type: "int64", Default: "0"},
+					BatchSize:     10,
+					CheckpointKey: "my-worker:backfill",
+				}
+				cfg.Redis.Generation = config.GenerationConfig{Name: "g2", PointerKey: "my-worker:generation",
+					DeleteDelay: time.Hour}
+				cfg = cfg.BackfillJob()
+				clearRedisValues(ctx, "g2:my-worker:1000:key", "g2:my-worker:2000:key", "my-worker:generation",
+					"my-worker:generation:patterns", "my-worker:generation:retired", runner.cfg.Redis.CursorKey)
+
+				r := NewRunner(&cfg, db, redisClient, logger)
+				Expect(r.Backfill(ctx)).To(Succeed())
+
+				expectRedisValues(ctx, "my-worker:generation", "g2")
+				expectRedisValuesNotFound(ctx, runner.cfg.Redis.CursorKey)
+			})
+
+			It("should stop the job of a retired generation", func() {
+				live := *runner.cfg
+				live.Redis.Generation = config.GenerationConfig{Name: "g1", PointerKey: "my-worker:generation",
+					DeleteDelay: time.Hour}
+				live.Redis.CursorKey = "my-worker:latest-g1"
+				clearRedisValues(ctx, "g1:my-worker:1000:key", "g1:my-worker:2000:key", live.Redis.CursorKey,
+					"my-worker:generation:retired")
+				redisClient.SAdd(ctx, "my-worker:generation:retired", "g1")
+
+				r := NewRunner(&live, db, redisClient, logger)
+				Expect(r.Run(ctx)).To(Succeed())
+				expectRedisValuesNotFound(ctx, "g1:my-worker:1000:key", "g1:my-worker:2000:key", live.Redis.CursorKey)
+			})
+
+			It("should expire the keys written while the generation was retired", func() {
+				live := *runner.cfg
+				live.Redis.Generation = config.GenerationConfig{Name: "g1", PointerKey: "my-worker:generation",
+					DeleteDelay: time.Hour}
+				live.Redis.CursorKey = "my-worker:latest-g1"
+				clearRedisValues(ctx, "g1:my-worker:1000:key", "g1:my-worker:2000:key", live.Redis.CursorKey,
+					"my-worker:generation:retired")
+
+				// The generation is retired while the batch is written
+				retiringClient := redis.NewClient(redisClient.Options())
+				defer retiringClient.Close()
+				retiringClient.AddHook(&afterPipelineHook{fn: func() {
+					redisClient.SAdd(ctx, "my-worker:generation:retired", "g1")
+				}})
+
+				r := NewRunner(&live, db, retiringClient, logger)
+				Expect(r.Run(ctx)).To(Succeed())
+				Expect(redisClient.TTL(ctx, "g1:my-worker:1000:key").Val()).To(BeNumerically("~", time.Hour, time.Minute))
+				Expect(redisClient.TTL(ctx, "g1:my-worker:2000:key").Val()).To(BeNumerically("~", time.Hour, time.Minute))
+			})
+		})
+
+		Context("with verify", func() {
+			var cfg config.JobConfig
+
+			BeforeEach(func() {
+				deleteFrom("sample_table", 4)
+				cfg = *runner.cfg
+				cfg.Redis.Key = "verify:${partition_key}:key"
+				cfg.DB.Backfill = config.BackfillConfig{
+					SelectQuery: `SELECT MAX(id) AS id, partition_key FROM sample_table WHERE id > $1
+						GROUP BY partition_key ORDER BY id LIMIT 10`,
+					Cursor:    config.CursorConfig{Column: "id", Type: "int64", Default: "0"},
+					BatchSize: 10,
+				}
+				cfg = cfg.BackfillJob()
+				clearRedisValues(ctx, "verify:1000:key", "verify:2000:key", "verify:3000:key")
+				redisClient.Set(ctx, "verify:1000:key", "1", 0)
+				redisClient.Set(ctx, "verify:3000:key", "4", 0)
+			})
+
+			It("should report and repair the keys that differ", func() {
+				r := NewRunner(&cfg, db, redisClient, logger)
+				report, err := r.Verify(ctx, VerifyOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*report).To(Equal(VerifyReport{Rows: 2, Missing: 1, Stale: 1, Extra: 1}))
+				expectRedisValues(ctx, "verify:1000:key", "1")
+
+				report, err = r.Verify(ctx, VerifyOptions{Repair: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Repaired).To(Equal(2))
+				expectRedisValues(ctx, "verify:3000:key", "4")
+
+				report, err = r.Verify(ctx, VerifyOptions{DeleteExtra: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Repaired).To(Equal(1))
+				expectRedisValues(ctx, "verify:1000:key", "2")
+				expectRedisValues(ctx, "verify:2000:key", "3")
+				expectRedisValuesNotFound(ctx, "verify:3000:key")
+
+				report, err = r.Verify(ctx, VerifyOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Differences()).To(BeZero())
+			})
+
+			It("should not report the keys written by the live job", func() {
+				live := *runner.cfg
+				live.Redis.Key = cfg.Redis.Key
+				live.Redis.CursorKey = "verify:cursor:key"
+				live.Redis.MarkerKey = "verify:marker:key"
+				live.DB.Backfill = cfg.DB.Backfill
+				verifyCfg := live.BackfillJob()
+				redisClient.Set(ctx, live.Redis.CursorKey, "3", 0)
+				redisClient.Set(ctx, live.Redis.MarkerKey, "3", 0)
+				DeferCleanup(func() { clearRedisValues(ctx, live.Redis.CursorKey, live.Redis.MarkerKey) })
+
+				r := NewRunner(&verifyCfg, db, redisClient, logger)
+				report, err := r.Verify(ctx, VerifyOptions{DeleteExtra: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Extra).To(Equal(1))
+				expectRedisValues(ctx, live.Redis.CursorKey, "3")
+				expectRedisValues(ctx, live.Redis.MarkerKey, "3")
+			})
+
+			It("should not report the keys of the other jobs", func() {
+				other := *runner.cfg
+				other.Name = "orders"
+				other.Redis.Key = "verify:orders:${id}:key"
+				other.Redis.CursorKey = "verify:orders:cursor:key"
+				redisClient.Set(ctx, "verify:orders:1:key", "1", 0)
+				redisClient.Set(ctx, other.Redis.CursorKey, "1", 0)
+				DeferCleanup(func() { clearRedisValues(ctx, "verify:orders:1:key", other.Redis.CursorKey) })
+
+				r := NewRunner(&cfg, db, redisClient, logger)
+				report, err := r.Verify(ctx, VerifyOptions{DeleteExtra: true, OtherJobs: []config.JobConfig{other}})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Extra).To(Equal(1))
+				Expect(report.Repaired).To(Equal(1))
+				expectRedisValuesNotFound(ctx, "verify:3000:key")
+				expectRedisValues(ctx, "verify:orders:1:key", "1")
+				expectRedisValues(ctx, other.Redis.CursorKey, "1")
+			})
+
+			It("should only count the repaired keys that were written", func() {
+				cfg.Redis.VersionColumn = "id"
+				redisClient.Set(ctx, "verify:1000:key:version", "10", 0)
+				DeferCleanup(func() { clearRedisValues(ctx, "verify:1000:key:version", "verify:2000:key:version") })
+
+				r := NewRunner(&cfg, db, redisClient, logger)
+				report, err := r.Verify(ctx, VerifyOptions{Repair: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Stale).To(Equal(1))
+				Expect(report.Missing).To(Equal(1))
+				Expect(report.Repaired).To(Equal(1))
+				expectRedisValues(ctx, "verify:1000:key", "1")
+				expectRedisValues(ctx, "verify:2000:key", "3")
+			})
+
+			It("should not delete the extra keys without a literal prefix", func() {
+				cfg.Redis.Key = "${partition_key}:verify"
+				r := NewRunner(&cfg, db, redisClient, logger)
+				_, err := r.Verify(ctx, VerifyOptions{DeleteExtra: true})
+				Expect(err).To(MatchError(ContainSubstring("literal prefix")))
+			})
+
+			It("should only verify the rows in the range", func() {
+				r := NewRunner(&cfg, db, redisClient, logger)
+				report, err := r.Verify(ctx, VerifyOptions{From: "2", To: "3"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*report).To(Equal(VerifyReport{Rows: 1, Missing: 1}))
+
+				report, err = r.Verify(ctx, VerifyOptions{To: "2"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*report).To(Equal(VerifyReport{Rows: 1, Stale: 1}))
+			})
+		})
+
+		Context("with marker key", func() {
+			var sqlStore *SQLCursorStore
+
+			BeforeEach(func() {
+				deleteFrom("sample_table", 4)
+				_, err := db.Exec("DELETE FROM worker_cursors")
+				Expect(err).NotTo(HaveOccurred())
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				sqlStore = NewSQLCursorStore(db, dialect, "worker_cursors", "")
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key", "my-worker:marker")
+			})
+
+			markerRunner := func(stores ...CursorStore) *Runner {
+				cfg := *runner.cfg
+				cfg.Redis.MarkerKey = "my-worker:marker"
+				return NewRunner(&cfg, db, redisClient, logger, WithCursorStores(stores...))
+			}
+
+			It("should resync from the default value when the marker is lost", func() {
+				r := markerRunner(sqlStore)
+				Expect(r.Run(ctx)).To(Succeed())
+				expectRedisValues(ctx, "my-worker:marker", "3")
+				resyncs := counterValue(r.metrics.counters.Get(metricResyncs))
+
+				// Redis restarted without persistence
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key", "my-worker:marker")
+				Expect(r.Run(ctx)).To(Succeed())
+				expectRedisValues(ctx, "my-worker:1000:key", "2")
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				Expect(counterValue(r.metrics.counters.Get(metricResyncs))).To(Equal(resyncs + 1))
+
+				// A new worker detects the data loss with the cursor stored outside of redis
+				clearRedisValues(ctx, "my-worker:1000:key", "my-worker:2000:key", "my-worker:marker")
+				Expect(markerRunner(sqlStore).Run(ctx)).To(Succeed())
+				expectRedisValues(ctx, "my-worker:1000:key", "2")
+			})
+
+			It("should resync from the marker when it's behind the cursor", func() {
+				Expect(sqlStore.Set(ctx, runner.cfg.Redis.CursorKey, "3")).To(Succeed())
+				redisClient.Set(ctx, "my-worker:marker", "2", 0)
+
+				Expect(markerRunner(sqlStore).Run(ctx)).To(Succeed())
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key")
+				expectRedisValues(ctx, "my-worker:marker", "3")
+			})
+
+			It("should not resync on the first run with the marker", func() {
+				redisStore := NewRedisCursorStore(redisClient)
+				redisClient.Set(ctx, runner.cfg.Redis.CursorKey, "2", 0)
+
+				r := markerRunner(redisStore)
+				resyncs := counterValue(r.metrics.counters.Get(metricResyncs))
+				Expect(r.Run(ctx)).To(Succeed())
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key")
+				expectRedisValues(ctx, "my-worker:marker", "3")
+				Expect(counterValue(r.metrics.counters.Get(metricResyncs))).To(Equal(resyncs))
+			})
+
+			It("should seed the marker on the first run with a cursor stored outside of redis", func() {
+				Expect(sqlStore.Set(ctx, runner.cfg.Redis.CursorKey, "2")).To(Succeed())
+
+				r := markerRunner(sqlStore)
+				resyncs := counterValue(r.metrics.counters.Get(metricResyncs))
+				Expect(r.Run(ctx)).To(Succeed())
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				expectRedisValuesNotFound(ctx, "my-worker:1000:key")
+				expectRedisValues(ctx, "my-worker:marker", "3")
+				Expect(counterValue(r.metrics.counters.Get(metricResyncs))).To(Equal(resyncs))
+				Expect(sqlStore.Get(ctx, "my-worker:marker")).To(Equal("2"))
+
+				// A new worker detects the data loss once the marker was seeded
+				clearRedisValues(ctx, "my-worker:2000:key", "my-worker:marker")
+				Expect(markerRunner(sqlStore).Run(ctx)).To(Succeed())
+				expectRedisValues(ctx, "my-worker:1000:key", "2")
+				expectRedisValues(ctx, "my-worker:2000:key", "3")
+				Expect(counterValue(r.metrics.counters.Get(metricResyncs))).To(Equal(resyncs + 1))
+			})
+		})
+
+		Context("with redis ring", func() {
+			BeforeEach(func() {
+				deleteFrom("sample_table", 4)
+			})
+
+			It("should store the cursor on the cursor node", func() {
+				addr := redisClient.Options().Addr
+				ring := redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"a": addr, "b": addr}, DB: 1})
+				defer ring.Close()
+				cursorClient := redis.NewClient(&redis.Options{Addr: addr, DB: 2})
+				defer cursorClient.Close()
+				for _, key := range []string{"my-worker:1000:key", "my-worker:2000:key", runner.cfg.Redis.CursorKey} {
+					ring.Del(ctx, key)
+				}
+				cursorClient.Del(ctx, runner.cfg.Redis.CursorKey)
+
+				r := NewRunner(runner.cfg, db, ring, logger, WithCursorClient(cursorClient))
+				Expect(r.sharded).To(BeTrue())
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(ring.Get(ctx, "my-worker:1000:key").Val()).To(Equal("2"))
+				Expect(ring.Get(ctx, "my-worker:2000:key").Val()).To(Equal("3"))
+				Expect(ring.Exists(ctx, runner.cfg.Redis.CursorKey).Val()).To(BeZero())
+				Expect(cursorClient.Get(ctx, runner.cfg.Redis.CursorKey).Val()).To(Equal("3"))
+			})
+		})
+
+		Context("with redis cluster", func() {
+			BeforeEach(func() {
+				deleteFrom("sample_table", 4)
+			})
+
+			It("should write the batch split per hash slot", func() {
+				redisConfig := config.RedisConnectionConfig{Addrs: redisClusterAddrs(), Cluster: true}
+				client, err := redisConfig.NewClient()
+				Expect(err).NotTo(HaveOccurred())
+				defer client.Close()
+				for _, key := range []string{"my-worker:1000:key", "my-worker:2000:key", runner.cfg.Redis.CursorKey} {
+					client.Del(ctx, key)
+				}
+
+				r := NewRunner(runner.cfg, db, client, logger)
+				Expect(r.sharded).To(BeTrue())
+
+				err = r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(client.Get(ctx, "my-worker:1000:key").Val()).To(Equal("2"))
+				Expect(client.Get(ctx, "my-worker:2000:key").Val()).To(Equal("3"))
+				Expect(client.Get(ctx, runner.cfg.Redis.CursorKey).Val()).To(Equal("3"))
+			})
+		})
+
+		Context("with typed table", func() {
+			It("should decode the column types", func() {
+				skipUnlessPostgres()
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = "SELECT id, uid, amount, payload, tags, data, created_at FROM typed_table WHERE id > $1"
+				cfg.Redis.Key = "typed:${uid}"
+				cfg.Redis.CursorKey = "my-worker:latest-typed"
+				cfg.Redis.ValueFormat = config.ValueFormatJSON
+				redisClient.Del(ctx, cfg.Redis.CursorKey)
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(redisClient.Get(ctx, "typed:8afb5e31-d8a6-4d92-b964-6ad8cc296050").Val()).To(MatchJSON(`{
+					"id": 1,
+					"uid": "8afb5e31-d8a6-4d92-b964-6ad8cc296050",
+					"amount": 12.5,
+					"payload": {"a": 1},
+					"tags": ["x", "y z"],
+					"data": "cafe",
+					"created_at": "2024-10-01T12:30:00Z"
+				}`))
+			})
+		})
+
+		Context("with nullable table", func() {
+			It("should skip the rows with null keys", func() {
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = "SELECT id, partition_key FROM nullable_table WHERE id > $1"
+				cfg.Redis.Key = "nullable:${partition_key}"
+				cfg.Redis.CursorKey = "my-worker:latest-nullable"
+				cfg.Redis.KeyNull = config.NullPolicyConfig{Policy: config.NullPolicySkip}
+				redisClient.Del(ctx, cfg.Redis.CursorKey)
+				r := NewRunner(&cfg, db, redisClient, logger)
+				skipped := r.metrics.counters.Get(metricNullRowSkipped)
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectRedisValues(ctx, "nullable:1000", "1")
+				expectRedisValuesNotFound(ctx, "nullable:<nil>")
+				expectRedisValues(ctx, cfg.Redis.CursorKey, "2")
+				Expect(r.metrics.counters.Get(metricNullRowSkipped).String()).To(Equal(fmt.Sprint(counterValue(skipped) + 1)))
+			})
+		})
+
+		Context("with soft delete table", func() {
+			It("should delete the keys of deleted rows", func() {
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = "SELECT id, name, deleted_at FROM soft_delete_table WHERE id > $1"
+				cfg.Redis.Key = "soft:${name}"
+				cfg.Redis.Value = "${id}"
+				cfg.Redis.CursorKey = "my-worker:latest-soft-delete"
+				cfg.Redis.DeleteWhen = config.DeleteConditionConfig{Column: "deleted_at", NotNull: true}
+				cfg.Redis.DeleteCommand = config.DeleteCommandUnlink
+				redisClient.Del(ctx, cfg.Redis.CursorKey)
+				redisClient.Set(ctx, "soft:b", "old", 0)
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectRedisValues(ctx, "soft:a", "1")
+				expectRedisValuesNotFound(ctx, "soft:b")
+				expectRedisValues(ctx, cfg.Redis.CursorKey, "2")
+			})
+		})
+
+		Context("with event table", func() {
+			It("should not skip rows sharing the cursor value at the batch boundary", func() {
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = "SELECT id, revision, name FROM event_table WHERE (revision, id) > ($1, $2) " +
+					"ORDER BY revision, id"
+				cfg.DB.Cursor = config.CursorConfig{Column: "revision,id", Type: "int64", Default: "-1"}
+				cfg.BatchSize = 1
+				cfg.Redis.Key = "event:${name}"
+				cfg.Redis.Value = "${id}"
+				cfg.Redis.CursorKey = "my-worker:latest-event"
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg.BindSelectQuery(dialect)).To(Succeed())
+				clearRedisValues(ctx, "event:a", "event:b", "event:c")
+				redisClient.Set(ctx, cfg.Redis.CursorKey, `["10","1"]`, 0)
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err = r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectRedisValuesNotFound(ctx, "event:a")
+				expectRedisValues(ctx, "event:b", "2")
+				expectRedisValues(ctx, "event:c", "3")
+				expectRedisValues(ctx, cfg.Redis.CursorKey, `["20","3"]`)
+			})
+
+			It("should use a timestamp cursor", func() {
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = "SELECT id, name, updated_at FROM event_table WHERE updated_at > $1"
+				cfg.DB.Cursor = config.CursorConfig{Column: "updated_at", Type: "timestamptz", Default: "1970-01-01"}
+				cfg.Redis.Key = "event:${name}"
+				cfg.Redis.Value = "${id}"
+				cfg.Redis.CursorKey = "my-worker:latest-event-time"
+				clearRedisValues(ctx, "event:a", "event:b", "event:c")
+				redisClient.Set(ctx, cfg.Redis.CursorKey, "2024-10-01T12:00:00Z", 0)
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				err := r.Run(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				expectRedisValuesNotFound(ctx, "event:a")
+				expectRedisValues(ctx, "event:b", "2")
+				expectRedisValues(ctx, "event:c", "3")
+				expectRedisValues(ctx, cfg.Redis.CursorKey, "2024-10-01T12:30:00Z")
+			})
+
+			Context("with lookback", func() {
+				once := context.WithValue(ctx, ctxKey("test-max-iterations"), 1)
+
+				BeforeEach(func() {
+					clearRedisValues(ctx, "event:a", "event:b", "event:c")
+				})
+
+				lookbackRunner := func(cursor config.CursorConfig, query string) *Runner {
+					cfg := *runner.cfg
+					cfg.DB.SelectQuery = query
+					cfg.DB.Cursor = cursor
+					cfg.Redis.Key = "event:${name}"
+					cfg.Redis.Value = "${id}"
+					cfg.Redis.CursorKey = "my-worker:latest-event-lookback"
+					redisClient.Del(ctx, cfg.Redis.CursorKey)
+					return NewRunner(&cfg, db, redisClient, logger)
+				}
+
+				hideEvent := func(id int64) {
+					_, err := db.Exec("UPDATE event_table SET id = -id WHERE id = $1", id)
+					Expect(err).NotTo(HaveOccurred())
+					DeferCleanup(func() {
+						_, err := db.Exec("UPDATE event_table SET id = -id WHERE id = $1", -id)
+						Expect(err).NotTo(HaveOccurred())
+					})
+				}
+
+				It("should wait for the late committed rows in the gaps", func() {
+					hideEvent(2)
+					r := lookbackRunner(
+						config.CursorConfig{Column: "id", Type: "int64", Default: "0", Lookback: config.LookbackConfig{IDs: 10}},
+						"SELECT id, name FROM event_table WHERE id > $1 ORDER BY id")
+					detected := counterValue(r.metrics.counters.Get(metricGapsDetected))
+					filled := counterValue(r.metrics.counters.Get(metricGapsFilled))
+
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValues(ctx, "event:a", "1")
+					expectRedisValues(ctx, "event:c", "3")
+					expectRedisValues(ctx, r.cfg.Redis.CursorKey, "1")
+					Expect(counterValue(r.metrics.counters.Get(metricGapsDetected))).To(Equal(detected + 1))
+
+					// The late commit is written and the rows already read are not written again
+					_, err := db.Exec("UPDATE event_table SET id = 2 WHERE id = -2")
+					Expect(err).NotTo(HaveOccurred())
+					clearRedisValues(ctx, "event:c")
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValues(ctx, "event:b", "2")
+					expectRedisValuesNotFound(ctx, "event:c")
+					expectRedisValues(ctx, r.cfg.Redis.CursorKey, "3")
+					Expect(counterValue(r.metrics.counters.Get(metricGapsFilled))).To(Equal(filled + 1))
+				})
+
+				It("should write the rows again when the batch fails", func() {
+					failingClient := redis.NewClient(redisClient.Options())
+					defer failingClient.Close()
+					failingClient.AddHook(&failingPipelineHook{failures: 1})
+
+					// The row after the gap is tracked as written until the gap is filled
+					hideEvent(2)
+					r := lookbackRunner(
+						config.CursorConfig{Column: "id", Type: "int64", Default: "0", Lookback: config.LookbackConfig{IDs: 10}},
+						"SELECT id, name FROM event_table WHERE id > $1 ORDER BY id")
+					r = NewRunner(r.cfg, db, failingClient, logger)
+					detected := counterValue(r.metrics.counters.Get(metricGapsDetected))
+
+					Expect(r.Run(once)).To(MatchError(ContainSubstring("pipeline failed")))
+					expectRedisValuesNotFound(ctx, "event:a", "event:c", r.cfg.Redis.CursorKey)
+					Expect(counterValue(r.metrics.counters.Get(metricGapsDetected))).To(Equal(detected))
+
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValues(ctx, "event:a", "1")
+					expectRedisValues(ctx, "event:c", "3")
+					expectRedisValues(ctx, r.cfg.Redis.CursorKey, "1")
+					Expect(counterValue(r.metrics.counters.Get(metricGapsDetected))).To(Equal(detected + 1))
+				})
+
+				It("should advance the cursor when the gaps expire", func() {
+					hideEvent(2)
+					r := lookbackRunner(
+						config.CursorConfig{Column: "id", Type: "int64", Default: "0", Lookback: config.LookbackConfig{IDs: 10}},
+						"SELECT id, name FROM event_table WHERE id > $1 ORDER BY id")
+					expired := counterValue(r.metrics.counters.Get(metricGapsExpired))
+
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValues(ctx, r.cfg.Redis.CursorKey, "1")
+
+					r.lookback.now = func() time.Time { return time.Now().Add(config.DefaultGapTimeout) }
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValues(ctx, r.cfg.Redis.CursorKey, "3")
+					Expect(counterValue(r.metrics.counters.Get(metricGapsExpired))).To(Equal(expired + 1))
+				})
+
+				It("should trail the current time by the window", func() {
+					r := lookbackRunner(
+						config.CursorConfig{
+							Column:   "updated_at",
+							Type:     "timestamptz",
+							Default:  "2024-10-01T12:00:00Z",
+							Lookback: config.LookbackConfig{Window: time.Hour},
+						},
+						"SELECT id, name, updated_at FROM event_table WHERE updated_at > $1")
+					r.lookback.now = func() time.Time { return time.Date(2024, 10, 1, 12, 45, 0, 0, time.UTC) }
+
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValues(ctx, "event:b", "2")
+					expectRedisValues(ctx, r.cfg.Redis.CursorKey, "2024-10-01T12:00:00Z")
+
+					r.lookback.now = func() time.Time { return time.Date(2024, 10, 1, 13, 40, 0, 0, time.UTC) }
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValues(ctx, r.cfg.Redis.CursorKey, "2024-10-01T12:30:00Z")
+				})
+
+				It("should not write the rows in the window again", func() {
+					r := lookbackRunner(
+						config.CursorConfig{
+							Column:   "updated_at",
+							Type:     "timestamptz",
+							Default:  "2024-10-01T12:00:00Z",
+							Lookback: config.LookbackConfig{Window: time.Hour},
+						},
+						"SELECT id, name, updated_at FROM event_table WHERE updated_at > $1 ORDER BY updated_at, id")
+					r.lookback.now = func() time.Time { return time.Date(2024, 10, 1, 12, 45, 0, 0, time.UTC) }
+
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValues(ctx, "event:b", "2")
+					expectRedisValues(ctx, "event:c", "3")
+
+					clearRedisValues(ctx, "event:b", "event:c")
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValuesNotFound(ctx, "event:b", "event:c")
+
+					// A row updated within the window is written again
+					_, err := db.Exec("UPDATE event_table SET updated_at = '2024-10-01 12:40:00+00:00' WHERE id = 3")
+					Expect(err).NotTo(HaveOccurred())
+					DeferCleanup(func() {
+						_, err := db.Exec("UPDATE event_table SET updated_at = '2024-10-01 12:30:00+00:00' WHERE id = 3")
+						Expect(err).NotTo(HaveOccurred())
+					})
+					Expect(r.Run(once)).To(Succeed())
+					expectRedisValuesNotFound(ctx, "event:b")
+					expectRedisValues(ctx, "event:c", "3")
+				})
+			})
+		})
+
+		Context("with drain", func() {
+			It("should poll again without delay while the batches are full", func() {
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = "SELECT id, name FROM event_table WHERE id > :cursor ORDER BY id LIMIT :batch_size"
+				cfg.DB.Cursor = config.CursorConfig{Column: "id", Type: "int64", Default: "0"}
+				cfg.Redis.Key = "event:${name}"
+				cfg.Redis.CursorKey = "my-worker:latest-event-drain"
+				cfg.BatchSize = 1
+				cfg.PollDelay = time.Minute
+				cfg.Drain = config.DrainConfig{Enabled: true}
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg.BindSelectQuery(dialect)).To(Succeed())
+				redisClient.Del(ctx, cfg.Redis.CursorKey)
+
+				// Fails when waiting for the poll delay
+				timeoutCtx, cancel := context.WithTimeout(context.WithValue(ctx, ctxKey("test-max-iterations"), 3),
+					5*time.Second)
+				defer cancel()
+				Expect(NewRunner(&cfg, db, redisClient, logger).Run(timeoutCtx)).To(Succeed())
+				expectRedisValues(ctx, cfg.Redis.CursorKey, "3")
+			})
+
+			It("should wait for the poll delay when the cursor does not advance", func() {
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = `SELECT id, name, updated_at FROM event_table WHERE updated_at >= :cursor
+					ORDER BY updated_at, id LIMIT :batch_size`
+				cfg.DB.Cursor = config.CursorConfig{Column: "updated_at", Type: "timestamptz", Default: "1970-01-01"}
+				cfg.Redis.Key = "event:${name}"
+				cfg.Redis.Value = "${id}"
+				cfg.Redis.CursorKey = "my-worker:latest-event-stuck"
+				cfg.BatchSize = 1
+				cfg.PollDelay = time.Minute
+				cfg.Drain = config.DrainConfig{Enabled: true}
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg.BindSelectQuery(dialect)).To(Succeed())
+				// The rows 2 and 3 share the cursor value, a batch of 1 row doesn't advance it
+				redisClient.Set(ctx, cfg.Redis.CursorKey, "2024-10-01T12:30:00Z", 0)
+				r := NewRunner(&cfg, db, redisClient, logger)
+				drainPolls := counterValue(r.metrics.counters.Get(metricDrainPolls))
+
+				timeoutCtx, cancel := context.WithTimeout(context.WithValue(ctx, ctxKey("test-max-iterations"), 3),
+					500*time.Millisecond)
+				defer cancel()
+				Expect(r.Run(timeoutCtx)).To(Succeed())
+				Expect(timeoutCtx.Err()).To(HaveOccurred())
+				Expect(counterValue(r.metrics.counters.Get(metricDrainPolls))).To(Equal(drainPolls))
+				expectRedisValues(ctx, cfg.Redis.CursorKey, "2024-10-01T12:30:00Z")
+			})
+
+			It("should not drain the rows read again in the lookback window", func() {
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = `SELECT id, name, updated_at FROM event_table WHERE updated_at > :cursor
+					ORDER BY updated_at, id LIMIT :batch_size`
+				cfg.DB.Cursor = config.CursorConfig{
+					Column:   "updated_at",
+					Type:     "timestamptz",
+					Default:  "2024-10-01T11:00:00Z",
+					Lookback: config.LookbackConfig{Window: time.Hour},
+				}
+				cfg.Redis.Key = "event:${name}"
+				cfg.Redis.Value = "${id}"
+				cfg.Redis.CursorKey = "my-worker:latest-event-window-drain"
+				cfg.BatchSize = 2
+				cfg.PollDelay = time.Minute
+				cfg.Drain = config.DrainConfig{Enabled: true}
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg.BindSelectQuery(dialect)).To(Succeed())
+				redisClient.Del(ctx, cfg.Redis.CursorKey)
+				r := NewRunner(&cfg, db, redisClient, logger)
+				// The window moves on each poll, advancing the cursor while the same rows are read again
+				now := time.Date(2024, 10, 1, 12, 45, 0, 0, time.UTC)
+				r.lookback.now = func() time.Time {
+					now = now.Add(time.Second)
+					return now
+				}
+				drainPolls := counterValue(r.metrics.counters.Get(metricDrainPolls))
+
+				timeoutCtx, cancel := context.WithTimeout(context.WithValue(ctx, ctxKey("test-max-iterations"), 3),
+					500*time.Millisecond)
+				defer cancel()
+				Expect(r.Run(timeoutCtx)).To(Succeed())
+				Expect(timeoutCtx.Err()).To(HaveOccurred())
+				Expect(counterValue(r.metrics.counters.Get(metricDrainPolls))).To(Equal(drainPolls + 1))
+				expectRedisValues(ctx, cfg.Redis.CursorKey, "2024-10-01T11:45:02Z")
+			})
+
+			It("should bound the catch-up rate", func() {
+				cfg := *runner.cfg
+				cfg.BatchSize = 10
+				cfg.PollDelay = time.Minute
+				cfg.Drain = config.DrainConfig{Enabled: true, MaxRowsPerSecond: 10}
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				Expect(r.nextPollDelay(5, true, time.Second)).To(Equal(time.Minute))
+				Expect(r.nextPollDelay(10, true, 100*time.Millisecond)).To(Equal(900 * time.Millisecond))
+				Expect(r.nextPollDelay(10, true, 2*time.Second)).To(BeZero())
+				Expect(r.nextPollDelay(10, false, 2*time.Second)).To(Equal(time.Minute))
+
+				cfg.Drain.Enabled = false
+				Expect(r.nextPollDelay(10, true, 100*time.Millisecond)).To(Equal(time.Minute))
+			})
+
+			It("should adapt the batch size to the latency", func() {
+				cfg := *runner.cfg
+				cfg.BatchSize = 10
+				cfg.Drain = config.DrainConfig{MinBatchSize: 4, MaxBatchSize: 20, TargetLatency: time.Second}
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				r.nextPollDelay(10, true, 100*time.Millisecond)
+				Expect(r.batchSize).To(Equal(15))
+				r.nextPollDelay(15, false, 100*time.Millisecond)
+				Expect(r.batchSize).To(Equal(15))
+				r.nextPollDelay(15, true, 100*time.Millisecond)
+				Expect(r.batchSize).To(Equal(20))
+				r.nextPollDelay(5, true, 100*time.Millisecond)
+				Expect(r.batchSize).To(Equal(20))
+				r.nextPollDelay(20, true, 2*time.Second)
+				Expect(r.batchSize).To(Equal(10))
+				r.nextPollDelay(10, true, 2*time.Second)
+				Expect(r.batchSize).To(Equal(5))
+				r.nextPollDelay(5, true, 2*time.Second)
+				Expect(r.batchSize).To(Equal(4))
+			})
+		})
+
+		Context("with snapshot cursor", func() {
+			It("should not read the rows of transactions above the snapshot xmin", func() {
+				skipUnlessPostgres()
+				once := context.WithValue(ctx, ctxKey("test-max-iterations"), 1)
+				cfg := *runner.cfg
+				cfg.DB.SelectQuery = `SELECT id, name, txid::text::bigint AS txid FROM event_table
+					WHERE txid > $1::text::xid8 AND txid < $2::text::xid8 ORDER BY txid`
+				cfg.DB.Cursor = config.CursorConfig{Column: "txid", Type: "snapshot", Default: "0"}
+				cfg.Redis.Key = "event:${name}"
+				cfg.Redis.Value = "${id}"
+				cfg.Redis.CursorKey = "my-worker:latest-event-snapshot"
+				dialect, err := (&config.DBConfig{DriverName: db.DriverName()}).Dialect()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg.BindSelectQuery(dialect)).To(Succeed())
+				redisClient.Del(ctx, cfg.Redis.CursorKey)
+				clearRedisValues(ctx, "event:d", "event:e")
+				DeferCleanup(func() {
+					_, err := db.Exec("DELETE FROM event_table WHERE id IN (4, 5)")
+					Expect(err).NotTo(HaveOccurred())
+				})
+				r := NewRunner(&cfg, db, redisClient, logger)
+
+				// The transaction gets an id lower than the one of the following insert but commits after it
+				tx, err := db.Begin()
+				Expect(err).NotTo(HaveOccurred())
+				_, err = tx.Exec("INSERT INTO event_table (id, revision, name) VALUES (4, 30, 'd')")
+				Expect(err).NotTo(HaveOccurred())
+				_, err = db.Exec("INSERT INTO event_table (id, revision, name) VALUES (5, 30, 'e')")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(r.Run(once)).To(Succeed())
+				expectRedisValues(ctx, "event:a", "1")
+				expectRedisValuesNotFound(ctx, "event:d", "event:e")
+
+				Expect(tx.Commit()).To(Succeed())
+				Expect(r.Run(once)).To(Succeed())
+				expectRedisValues(ctx, "event:d", "4")
+				expectRedisValues(ctx, "event:e", "5")
+			})
 		})
 
 		Context("with uuid table", func() {
 			It("should read", func() {
+				skipUnlessPostgres()
 				runner.cfg.DB.Cursor.Default = "00000000-0000-7300-8f14-e6ee9ef0c3f1"
 				runner.cfg.DB.Cursor.Type = "uuid"
 				runner.cfg.DB.SelectQuery = `
@@ -102,6 +1266,93 @@ var _ = Describe("Runner", func() {
 	})
 })
 
+// failingKeyHook replaces the SET command of the key with an invalid command, making the batch fail mid-pipeline.
+type failingKeyHook struct {
+	key string
+}
+
+func (h failingKeyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h failingKeyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h failingKeyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for i, cmd := range cmds {
+			if args := cmd.Args(); cmd.Name() == "set" && len(args) > 1 && args[1] == h.key {
+				cmds[i] = redis.NewStatusCmd(ctx, "set", h.key)
+			}
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// failingPipelineHook fails the first pipelines without sending them to redis.
+type failingPipelineHook struct {
+	failures int
+}
+
+func (h *failingPipelineHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *failingPipelineHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *failingPipelineHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if h.failures > 0 {
+			h.failures--
+			return errors.New("pipeline failed")
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// afterPipelineHook calls the function after each pipeline is executed.
+type afterPipelineHook struct {
+	fn func()
+}
+
+func (h *afterPipelineHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *afterPipelineHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *afterPipelineHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		h.fn()
+		return err
+	}
+}
+
+func counterValue(v expvar.Var) int64 {
+	if v == nil {
+		return 0
+	}
+	return v.(*expvar.Int).Value()
+}
+
+// redisClusterAddrs returns the seed addresses of the redis cluster started by the test harness.
+func redisClusterAddrs() []string {
+	if addrs := os.Getenv("TEST_REDIS_CLUSTER_ADDRS"); addrs != "" {
+		return strings.Split(addrs, ",")
+	}
+	if os.Getenv("TEST_REDIS_ADDR") == "" {
+		// The embedded redis server behaves as a single node cluster
+		return []string{redisClient.Options().Addr}
+	}
+	return []string{"localhost:7000", "localhost:7001", "localhost:7002"}
+}
+
 func expectRedisValues(ctx context.Context, key string, expected string) {
 	result := redisClient.Get(ctx, key)
 	Expect(result.Err()).NotTo(HaveOccurred(), "redis error for key %s", key)
@@ -115,13 +1366,13 @@ func expectRedisValuesNotFound(ctx context.Context, keys ...string) {
 
 func insert(table string, id, partitionKey any) {
 	query := fmt.Sprintf("INSERT INTO %s (id, partition_key) VALUES ($1, $2) ON CONFLICT DO NOTHING", table)
-	_, err := db.Queryx(query, id, partitionKey) //nolint:sqlclosecheck
+	_, err := db.Exec(query, id, partitionKey)
 	Expect(err).NotTo(HaveOccurred())
 }
 
 func deleteFrom(table string, id any) {
 	query := fmt.Sprintf("DELETE FROM %s WHERE id >= $1", table)
-	_, err := db.Queryx(query, id) //nolint:sqlclosecheck
+	_, err := db.Exec(query, id)
 	Expect(err).NotTo(HaveOccurred())
 }
 
@@ -134,38 +1385,67 @@ var _ = BeforeSuite(func() {
 
 	err := cleanenv.ReadEnv(&cfg)
 	Expect(err).NotTo(HaveOccurred())
-	cfg.DB.SelectQuery = "SELECT MAX(id) as id, partition_key FROM sample_table WHERE id > $1 GROUP BY partition_key"
-	cfg.PollDelay = 0
-	cfg.Redis.TimestampKey = "my-worker:writer-timestamp"
+	job := cfg.DefaultJob()
+	job.DB.SelectQuery = "SELECT MAX(id) as id, partition_key FROM sample_table WHERE id > $1 GROUP BY partition_key"
+	job.PollDelay = 0
+	job.Redis.TimestampKey = "my-worker:writer-timestamp"
 
+	// The tests use sqlite and an embedded redis server by default, set TEST_DB_DRIVER and TEST_REDIS_ADDR to use
+	// external services
+	cfg.DB.DriverName = cmp.Or(os.Getenv("TEST_DB_DRIVER"), config.DriverSQLite)
+	if cfg.DB.DriverName == config.DriverSQLite {
+		cfg.DB.DBName = filepath.Join(GinkgoT().TempDir(), "worker.db")
+	}
 	connString, err := cfg.DB.BuildConnectionString()
 	Expect(err).NotTo(HaveOccurred())
 	db, err = sqlx.Connect(cfg.DB.DriverName, connString)
 	Expect(err).NotTo(HaveOccurred())
 
-	opts, err := cfg.Redis.ClientOptions()
+	opts, err := cfg.Redis.UniversalOptions()
 	Expect(err).NotTo(HaveOccurred())
+	opts.Addrs = []string{os.Getenv("TEST_REDIS_ADDR")}
+	if opts.Addrs[0] == "" {
+		opts.Addrs[0] = miniredis.RunT(GinkgoT()).Addr()
+	}
 
-	redisClient = redis.NewClient(opts)
+	redisClient = redis.NewClient(opts.Simple())
 	redisStatus := redisClient.Ping(context.Background())
 	Expect(redisStatus.Err()).NotTo(HaveOccurred())
 
-	logger, err := zap.NewDevelopment()
+	logger, err = zap.NewDevelopment()
 	Expect(err).NotTo(HaveOccurred())
 
-	driver, err := postgres.WithInstance(db.DB, &postgres.Config{})
+	migrateDB(cfg.DB.DriverName)
+
+	runner = NewRunner(&job, db, redisClient, logger)
+})
+
+func migrateDB(driverName string) {
+	var (
+		driver database.Driver
+		err    error
+	)
+	switch driverName {
+	case config.DriverPostgres:
+		driver, err = postgres.WithInstance(db.DB, &postgres.Config{})
+	case config.DriverSQLite:
+		driver, err = sqlite3.WithInstance(db.DB, &sqlite3.Config{})
+	default:
+		Fail("unsupported test db driver: " + driverName)
+	}
 	Expect(err).NotTo(HaveOccurred())
-	migrator, err := migrate.NewWithDatabaseInstance("file://test/migrations", "postgres", driver)
+
+	migrator, err := migrate.NewWithDatabaseInstance("file://test/migrations/"+driverName, driverName, driver)
 	Expect(err).NotTo(HaveOccurred())
 	err = migrator.Up()
 	if err != migrate.ErrNoChange {
 		Expect(err).NotTo(HaveOccurred())
 	}
+}
 
-	runner = &Runner{
-		cfg:         &cfg,
-		db:          db,
-		redisClient: redisClient,
-		logger:      logger,
+// skipUnlessPostgres skips the specs that use postgres specific types and syntax.
+func skipUnlessPostgres() {
+	if db.DriverName() != config.DriverPostgres {
+		Skip("requires postgres")
 	}
-})
+}
@@ -0,0 +1,38 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jorgebay/write-behind-cache-worker/internal/config"
+	"go.uber.org/zap"
+)
+
+// snapshotXminQuery returns the lowest transaction id that is still in progress, every transaction with a lower id
+// is committed or rolled back.
+const snapshotXminQuery = "SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint"
+
+func (r *Runner) snapshotXmin(ctx context.Context) (int64, error) {
+	var xmin int64
+	if err := r.db.GetContext(ctx, &xmin, snapshotXminQuery); err != nil {
+		return 0, fmt.Errorf("unable to get the snapshot xmin: %w", err)
+	}
+	return xmin, nil
+}
+
+// snapshotCursor returns the next value of a snapshot cursor. When the batch is not full, every transaction below
+// the snapshot xmin was read. Otherwise, the rows are read again from the transaction of the last row, as the batch
+// may contain part of its rows.
+func (r *Runner) snapshotCursor(snapshot config.SnapshotCursor, maxValue any, readRows int) any {
+	cursor, _ := snapshot.Cursor.(int64)
+	if readRows < r.batchSize {
+		return max(cursor, snapshot.Xmin-1)
+	}
+
+	maxID, _ := maxValue.(int64)
+	if maxID-1 <= cursor {
+		r.logger.Warn("the rows of a single transaction exceed the batch size, consider incrementing it",
+			zap.Int64("transaction", maxID), zap.Int("batchSize", r.batchSize))
+	}
+	return max(cursor, maxID-1)
+}
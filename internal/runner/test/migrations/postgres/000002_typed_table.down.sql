@@ -0,0 +1 @@
+DROP TABLE typed_table;
@@ -0,0 +1,12 @@
+CREATE TABLE typed_table (
+    id BIGINT PRIMARY KEY,
+    uid UUID NOT NULL,
+    amount NUMERIC(20, 4) NOT NULL,
+    payload JSONB NOT NULL,
+    tags TEXT[] NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+INSERT INTO typed_table (id, uid, amount, payload, tags, data, created_at)
+VALUES (1, '8afb5e31-d8a6-4d92-b964-6ad8cc296050', 12.5, '{"a": 1}', '{x,"y z"}', '\xcafe', '2024-10-01 12:30:00+00');
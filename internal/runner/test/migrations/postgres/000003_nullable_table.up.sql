@@ -0,0 +1,7 @@
+CREATE TABLE nullable_table (
+    id BIGINT PRIMARY KEY,
+    partition_key BIGINT NULL
+);
+
+INSERT INTO nullable_table (id, partition_key) VALUES (1, 1000);
+INSERT INTO nullable_table (id, partition_key) VALUES (2, NULL);
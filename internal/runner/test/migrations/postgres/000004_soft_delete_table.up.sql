@@ -0,0 +1,8 @@
+CREATE TABLE soft_delete_table (
+    id BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    deleted_at TIMESTAMPTZ NULL
+);
+
+INSERT INTO soft_delete_table (id, name, deleted_at) VALUES (1, 'a', NULL);
+INSERT INTO soft_delete_table (id, name, deleted_at) VALUES (2, 'b', '2024-10-01 12:30:00+00');
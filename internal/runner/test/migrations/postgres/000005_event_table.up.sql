@@ -0,0 +1,9 @@
+CREATE TABLE event_table (
+    id BIGINT PRIMARY KEY,
+    revision BIGINT NOT NULL,
+    name TEXT NOT NULL
+);
+
+INSERT INTO event_table (id, revision, name) VALUES (1, 10, 'a');
+INSERT INTO event_table (id, revision, name) VALUES (2, 10, 'b');
+INSERT INTO event_table (id, revision, name) VALUES (3, 20, 'c');
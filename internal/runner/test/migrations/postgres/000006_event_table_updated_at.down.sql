@@ -0,0 +1 @@
+ALTER TABLE event_table DROP COLUMN updated_at;
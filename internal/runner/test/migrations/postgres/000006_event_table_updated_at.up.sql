@@ -0,0 +1,4 @@
+ALTER TABLE event_table ADD COLUMN updated_at TIMESTAMPTZ NULL;
+
+UPDATE event_table SET updated_at = '2024-10-01 12:00:00+00:00' WHERE id = 1;
+UPDATE event_table SET updated_at = '2024-10-01 12:30:00+00:00' WHERE id IN (2, 3);
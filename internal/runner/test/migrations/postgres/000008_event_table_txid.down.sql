@@ -0,0 +1 @@
+ALTER TABLE event_table DROP COLUMN txid;
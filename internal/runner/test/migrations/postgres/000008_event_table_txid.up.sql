@@ -0,0 +1 @@
+ALTER TABLE event_table ADD COLUMN txid xid8 NOT NULL DEFAULT pg_current_xact_id();
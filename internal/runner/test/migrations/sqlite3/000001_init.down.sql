@@ -0,0 +1,2 @@
+DROP TABLE uuid_table;
+DROP TABLE sample_table;
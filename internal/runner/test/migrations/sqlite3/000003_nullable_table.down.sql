@@ -0,0 +1 @@
+DROP TABLE nullable_table;
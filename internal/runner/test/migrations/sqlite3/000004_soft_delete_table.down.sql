@@ -0,0 +1 @@
+DROP TABLE soft_delete_table;
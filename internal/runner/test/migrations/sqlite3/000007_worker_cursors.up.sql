@@ -0,0 +1,4 @@
+CREATE TABLE worker_cursors (
+    cursor_key VARCHAR(255) PRIMARY KEY,
+    cursor_value TEXT NOT NULL
+);
@@ -0,0 +1,477 @@
+package runner
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jorgebay/write-behind-cache-worker/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// VerifyOptions defines the rows compared by Verify.
+type VerifyOptions struct {
+	// From and To limit the verified rows to the ones with a cursor greater than From and up to To. When empty, the
+	// rows are read from the default value of the cursor to the last row.
+	From string
+	To   string
+
+	// SampleRate is the fraction of the rows verified, between 0 and 1. When 0, all the rows are verified.
+	SampleRate float64
+
+	// Repair rewrites the missing and stale keys.
+	Repair bool
+
+	// DeleteExtra removes the extra keys, only allowed when the key template starts with a literal prefix, so the
+	// scanned keys are not shared with unrelated data.
+	DeleteExtra bool
+
+	// OtherJobs are the other jobs writing to redis, the keys matching their key patterns and their reserved keys are
+	// not reported as extra keys, e.g. "users:${id}:orders" keys when verifying "users:${id}".
+	OtherJobs []config.JobConfig
+}
+
+// VerifyReport contains the number of rows read and the number of keys that differ between redis and the db.
+type VerifyReport struct {
+	Rows     int
+	Missing  int
+	Stale    int
+	Extra    int
+	Repaired int
+}
+
+// Differences returns the number of keys that differ between redis and the db.
+func (r *VerifyReport) Differences() int {
+	return r.Missing + r.Stale + r.Extra
+}
+
+// Status of a key compared with the db.
+const (
+	keyStatusOK      = ""
+	keyStatusMissing = "missing"
+	keyStatusStale   = "stale"
+	keyStatusExtra   = "extra"
+)
+
+// expectedKey is the content of a key expected in redis for a row.
+type expectedKey struct {
+	key    string
+	row    map[string]any
+	absent bool
+	value  string
+	fields map[string]string
+}
+
+// Verify reads the rows with the select query and compares the keys and values built with the templates with the
+// ones in redis, reporting the missing, stale and extra keys. Keys without a row (extra) are only searched when all
+// the rows are verified, by scanning the keys matching the key template. The select query should return a single row
+// per key. The runner should be built with the backfill config of the job (config.JobConfig.BackfillJob) to read the
+// table in key-range chunks.
+func (r *Runner) Verify(ctx context.Context, opts VerifyOptions) (*VerifyReport, error) {
+	cursorInfo, err := r.cfg.DB.Cursor.Info()
+	if err != nil {
+		return nil, err
+	}
+	if cursorInfo.Snapshot() {
+		return nil, errors.New("verify is not supported with snapshot cursors, use a backfill select query")
+	}
+	if opts.DeleteExtra {
+		pattern, err := r.cfg.Redis.KeyPattern()
+		if err != nil {
+			return nil, err
+		}
+		if pattern == "" || pattern[0] == '*' {
+			return nil, errors.New("deleting the extra keys requires a literal prefix in the key template")
+		}
+	}
+
+	fns, err := r.rowFuncs()
+	if err != nil {
+		return nil, err
+	}
+
+	cursorValue := cursorInfo.Default
+	if opts.From != "" {
+		if cursorValue, err = cursorInfo.ConvertFunc(opts.From); err != nil {
+			return nil, fmt.Errorf("invalid from value: %w", err)
+		}
+	}
+	var to any
+	if opts.To != "" {
+		if to, err = cursorInfo.ConvertFunc(opts.To); err != nil {
+			return nil, fmt.Errorf("invalid to value: %w", err)
+		}
+	}
+
+	var seen map[string]bool
+	sampled := opts.SampleRate > 0 && opts.SampleRate < 1
+	if opts.From == "" && opts.To == "" && !sampled {
+		seen = make(map[string]bool)
+	}
+
+	report := &VerifyReport{}
+	for done := false; !done; {
+		var expected []*expectedKey
+		var readRows int
+		expected, readRows, cursorValue, done, err = r.readExpected(ctx, cursorInfo, fns, cursorValue, to, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Rows += readRows
+		if seen != nil {
+			for _, e := range expected {
+				seen[e.key] = true
+			}
+		}
+
+		if err := r.compareKeys(ctx, fns, expected, opts.Repair, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if seen != nil {
+		if err := r.findExtraKeys(ctx, seen, opts, report); err != nil {
+			return nil, err
+		}
+	}
+
+	r.logger.Info("verify completed", zap.Int("rows", report.Rows), zap.Int("missing", report.Missing),
+		zap.Int("stale", report.Stale), zap.Int("extra", report.Extra), zap.Int("repaired", report.Repaired))
+	return report, nil
+}
+
+// readExpected reads a chunk of rows from the cursor, returning the expected keys, the number of rows read, the next
+// cursor and whether the last row to verify was read.
+func (r *Runner) readExpected(
+	ctx context.Context,
+	cursorInfo *config.CursorInfo,
+	fns *rowFuncs,
+	cursorValue any,
+	to any,
+	opts VerifyOptions,
+) ([]*expectedKey, int, any, bool, error) {
+	rows, decode, err := r.query(ctx, cursorValue)
+	if err != nil {
+		return nil, 0, nil, false, err
+	}
+	defer rows.Close()
+
+	var expected []*expectedKey
+	readRows := 0
+	for rows.Next() {
+		m := make(map[string]any)
+		if err := rows.MapScan(m); err != nil {
+			return nil, 0, nil, false, fmt.Errorf("unable to map scan: %w", err)
+		}
+		if err := decode(m); err != nil {
+			return nil, 0, nil, false, err
+		}
+
+		rowCursorValue, err := cursorInfo.Value(m)
+		if err != nil {
+			return nil, 0, nil, false, err
+		}
+		if to != nil {
+			comparison, err := cursorInfo.CompareFunc(rowCursorValue, to)
+			if err != nil {
+				return nil, 0, nil, false, fmt.Errorf("unable to compare %v and %v: %w", rowCursorValue, to, err)
+			}
+			if comparison > 0 {
+				return expected, readRows, cursorValue, true, nil
+			}
+		}
+		readRows++
+
+		comparison, err := cursorInfo.CompareFunc(cursorValue, rowCursorValue)
+		if err != nil {
+			return nil, 0, nil, false, fmt.Errorf("unable to compare %v and %v: %w", cursorValue, rowCursorValue, err)
+		}
+		if comparison < 0 {
+			cursorValue = rowCursorValue
+		}
+
+		if opts.SampleRate > 0 && rand.Float64() >= opts.SampleRate { //nolint:gosec
+			continue
+		}
+		e, err := expectedRow(fns, m)
+		if err != nil {
+			return nil, 0, nil, false, err
+		}
+		if e != nil {
+			expected = append(expected, e)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, nil, false, err
+	}
+
+	return expected, readRows, cursorValue, readRows < r.batchSize, nil
+}
+
+// expectedRow returns the key expected for the row, or nil when the row is skipped by the null policies.
+func expectedRow(fns *rowFuncs, row map[string]any) (*expectedKey, error) {
+	key, err := fns.key(row)
+	if err != nil {
+		if errors.Is(err, config.ErrSkipRow) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get the key: %w", err)
+	}
+
+	e := &expectedKey{key: key, row: row}
+	if fns.deleted != nil && fns.deleted(row) {
+		e.absent = true
+		return e, nil
+	}
+	if _, err := fns.expiration(row); errors.Is(err, config.ErrKeyExpired) {
+		e.absent = true
+		return e, nil
+	}
+
+	if fns.fields != nil {
+		var fields map[string]any
+		if fields, err = fns.fields(row); err == nil {
+			e.fields = make(map[string]string, len(fields))
+			for name, value := range fields {
+				if e.fields[name], err = formatArg(value); err != nil {
+					break
+				}
+			}
+		}
+	} else {
+		var value any
+		if value, err = fns.value(row); err == nil {
+			e.value, err = formatArg(value)
+		}
+	}
+
+	switch {
+	case errors.Is(err, config.ErrSkipRow):
+		return nil, nil
+	case errors.Is(err, config.ErrDeleteKey):
+		e.absent = true
+	case err != nil:
+		return nil, fmt.Errorf("unable to get the value of key '%s': %w", key, err)
+	}
+	return e, nil
+}
+
+// compareKeys reads the expected keys from redis, reporting and optionally repairing the ones that differ.
+func (r *Runner) compareKeys(
+	ctx context.Context,
+	fns *rowFuncs,
+	expected []*expectedKey,
+	repair bool,
+	report *VerifyReport,
+) error {
+	if len(expected) == 0 {
+		return nil
+	}
+
+	pipeline := r.redisClient.Pipeline()
+	cmds := make([]redis.Cmder, len(expected))
+	for i, e := range expected {
+		switch {
+		case e.absent:
+			cmds[i] = pipeline.Exists(ctx, e.key)
+		case e.fields != nil:
+			cmds[i] = pipeline.HGetAll(ctx, e.key)
+		default:
+			cmds[i] = pipeline.Get(ctx, e.key)
+		}
+	}
+	// The errors are checked per command, GET returns redis.Nil for missing keys
+	_, _ = pipeline.Exec(ctx)
+
+	repairBatch := &batch{Pipeliner: r.pipeline()}
+	repairs := 0
+	for i, e := range expected {
+		status, err := keyStatus(e, cmds[i])
+		if err != nil {
+			return fmt.Errorf("unable to read key '%s': %w", e.key, err)
+		}
+
+		switch status {
+		case keyStatusOK:
+			continue
+		case keyStatusMissing:
+			report.Missing++
+		case keyStatusStale:
+			report.Stale++
+		case keyStatusExtra:
+			report.Extra++
+		}
+		r.logger.Warn("key differs from the db", zap.String("key", e.key), zap.String("status", status))
+
+		if repair {
+			// Rows skipped by the null policies don't queue any command
+			queued := repairBatch.Len()
+			if err := r.write(ctx, repairBatch, fns, e.row); err != nil {
+				return err
+			}
+			if repairBatch.Len() > queued {
+				repairs++
+			}
+		}
+	}
+
+	if repairBatch.Len() == 0 {
+		return nil
+	}
+	if err := r.loadVersionScript(ctx, repairBatch); err != nil {
+		return err
+	}
+	if _, err := repairBatch.Exec(ctx); err != nil {
+		return fmt.Errorf("unable to repair keys: %w", err)
+	}
+	// The writes skipped by the version guard are not repaired
+	report.Repaired += repairs - r.checkGuardedWrites(repairBatch)
+	return nil
+}
+
+// keyStatus compares the result of the read command with the expected key.
+func keyStatus(e *expectedKey, cmd redis.Cmder) (string, error) {
+	err := cmd.Err()
+	if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		return keyStatusStale, nil
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return keyStatusOK, err
+	}
+
+	switch cmd := cmd.(type) {
+	case *redis.IntCmd:
+		if cmd.Val() > 0 {
+			return keyStatusExtra, nil
+		}
+	case *redis.MapStringStringCmd:
+		if len(cmd.Val()) == 0 {
+			return keyStatusMissing, nil
+		}
+		if !maps.Equal(cmd.Val(), e.fields) {
+			return keyStatusStale, nil
+		}
+	case *redis.StringCmd:
+		if errors.Is(err, redis.Nil) {
+			return keyStatusMissing, nil
+		}
+		if cmd.Val() != e.value {
+			return keyStatusStale, nil
+		}
+	}
+	return keyStatusOK, nil
+}
+
+// findExtraKeys scans the keys matching the key template that don't belong to a row, excluding the keys written by
+// the worker (config.JobConfig.ReservedKeys and version keys) and the keys of the other jobs.
+func (r *Runner) findExtraKeys(
+	ctx context.Context,
+	seen map[string]bool,
+	opts VerifyOptions,
+	report *VerifyReport,
+) error {
+	pattern, err := r.cfg.Redis.KeyPattern()
+	if err != nil {
+		return err
+	}
+	reserved := make(map[string]bool)
+	for _, key := range r.cfg.ReservedKeys() {
+		reserved[key] = true
+	}
+	var otherPatterns []string
+	for i := range opts.OtherJobs {
+		job := &opts.OtherJobs[i]
+		patterns, err := job.Redis.KeyPatterns()
+		if err != nil {
+			return fmt.Errorf("invalid key of job '%s': %w", job.Name, err)
+		}
+		otherPatterns = append(otherPatterns, patterns...)
+		for _, key := range job.ReservedKeys() {
+			reserved[key] = true
+		}
+	}
+	otherJob := func(key string) bool {
+		return slices.ContainsFunc(otherPatterns, func(p string) bool { return config.MatchKeyPattern(p, key) })
+	}
+	versioned := func(key string) bool {
+		suffix := r.cfg.Redis.VersionKeySuffix
+		return r.cfg.Redis.VersionColumn != "" && strings.HasSuffix(key, suffix) && seen[strings.TrimSuffix(key, suffix)]
+	}
+
+	var mu sync.Mutex
+	return r.forEachNode(ctx, func(ctx context.Context, client *redis.Client) error {
+		iter := client.Scan(ctx, 0, pattern, scanCount).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			if seen[key] || reserved[key] || versioned(key) || otherJob(key) {
+				continue
+			}
+
+			r.logger.Warn("key differs from the db", zap.String("key", key), zap.String("status", keyStatusExtra))
+			mu.Lock()
+			report.Extra++
+			mu.Unlock()
+			if !opts.DeleteExtra {
+				continue
+			}
+
+			del := client.Del
+			if r.cfg.Redis.DeleteCommand == config.DeleteCommandUnlink {
+				del = client.Unlink
+			}
+			if err := del(ctx, key).Err(); err != nil {
+				return fmt.Errorf("unable to delete extra key '%s': %w", key, err)
+			}
+			mu.Lock()
+			report.Repaired++
+			mu.Unlock()
+		}
+		return iter.Err()
+	})
+}
+
+// formatArg returns the value as written by the redis client.
+func formatArg(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10), nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		return string(b), err
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,156 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jorgebay/write-behind-cache-worker/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Commands of the version script.
+const (
+	commandSet  = "set"
+	commandHSet = "hset"
+)
+
+// versionScript applies the command on the key only when the version is greater than the one stored in the version
+// key, storing the new version with the same expiration as the key. Returns 1 when applied and 0 otherwise. The
+// versions are decimal texts compared digit by digit, as Lua numbers lose precision above 2^53.
+// KEYS: key, version key. ARGV: version, command (set, hset, del or unlink), pxat, px, value or field/value pairs.
+var versionScript = redis.NewScript(`
+local function compare(a, b)
+  local negA, negB = a:sub(1, 1) == '-', b:sub(1, 1) == '-'
+  if negA ~= negB then
+    return negA and -1 or 1
+  end
+  local sign = negA and -1 or 1
+  local intA, fracA = a:match('^-?0*(%d*)%.?(%d*)$')
+  local intB, fracB = b:match('^-?0*(%d*)%.?(%d*)$')
+  if not intA or not intB then
+    local x, y = tonumber(a), tonumber(b)
+    return x < y and -1 or (x > y and 1 or 0)
+  end
+  if #intA ~= #intB then
+    return #intA < #intB and -sign or sign
+  end
+  local width = math.max(#fracA, #fracB)
+  a = intA .. fracA .. string.rep('0', width - #fracA)
+  b = intB .. fracB .. string.rep('0', width - #fracB)
+  if a == b then
+    return 0
+  end
+  return a < b and -sign or sign
+end
+
+local current = redis.call('GET', KEYS[2])
+if current and compare(current, ARGV[1]) >= 0 then
+  return 0
+end
+
+local command = ARGV[2]
+if command == 'set' then
+  redis.call('SET', KEYS[1], ARGV[5])
+elseif command == 'hset' then
+  redis.call('HSET', KEYS[1], unpack(ARGV, 5))
+else
+  redis.call(command, KEYS[1])
+end
+redis.call('SET', KEYS[2], ARGV[1])
+
+if command == 'set' or command == 'hset' then
+  local pxat, px = tonumber(ARGV[3]), tonumber(ARGV[4])
+  for i = 1, 2 do
+    if pxat > 0 then
+      redis.call('PEXPIREAT', KEYS[i], pxat)
+    elseif px > 0 then
+      redis.call('PEXPIRE', KEYS[i], px)
+    end
+  end
+end
+return 1
+`)
+
+// guardedWrite is a version-guarded write queued in the pipeline.
+type guardedWrite struct {
+	key     string
+	version string
+	cmd     *redis.Cmd
+}
+
+// guardedWrite queues the execution of the version script for the key.
+func (r *Runner) guardedWrite(
+	ctx context.Context,
+	b *batch,
+	key string,
+	version string,
+	command string,
+	expiration config.Expiration,
+	values ...any,
+) error {
+	var pxat, px int64
+	if !expiration.At.IsZero() {
+		pxat = expiration.At.UnixMilli()
+	} else if expiration.TTL > 0 {
+		px = expiration.TTL.Milliseconds()
+	}
+
+	args := append([]any{version, command, pxat, px}, values...)
+	cmd := versionScript.EvalSha(ctx, b, []string{key, key + r.cfg.Redis.VersionKeySuffix}, args...)
+	if err := cmd.Err(); err != nil {
+		return err
+	}
+
+	b.guarded = append(b.guarded, &guardedWrite{key: key, version: version, cmd: cmd})
+	return nil
+}
+
+// loadVersionScript makes sure the version script is cached by redis before executing the batch.
+func (r *Runner) loadVersionScript(ctx context.Context, b *batch) error {
+	if len(b.guarded) == 0 {
+		return nil
+	}
+
+	if ring, ok := r.redisClient.(*redis.Ring); ok {
+		// Script commands without keys are sent to a single shard of the ring
+		return ring.ForEachShard(ctx, func(ctx context.Context, client *redis.Client) error {
+			return loadVersionScriptOn(ctx, client)
+		})
+	}
+	return loadVersionScriptOn(ctx, r.redisClient)
+}
+
+func loadVersionScriptOn(ctx context.Context, client redis.Scripter) error {
+	if err := versionScript.Load(ctx, client).Err(); err != nil {
+		return fmt.Errorf("unable to load version script: %w", err)
+	}
+	return nil
+}
+
+// checkGuardedWrites reports the writes that were not applied because redis contained a newer version, returning
+// their number.
+func (r *Runner) checkGuardedWrites(b *batch) int {
+	skipped := 0
+	for _, w := range b.guarded {
+		if applied, err := w.cmd.Int(); err == nil && applied == 0 {
+			r.logger.Debug("skipping write of stale version", zap.String("key", w.key), zap.String("version", w.version))
+			skipped++
+		}
+	}
+
+	if skipped > 0 {
+		r.logger.Info("skipped writes of stale versions", zap.Int("writes", skipped))
+		r.metrics.add(metricStaleWritesSkipped, int64(skipped))
+	}
+	return skipped
+}
+
+// hsetArgs returns the fields as field/value pairs.
+func hsetArgs(fields map[string]any) []any {
+	args := make([]any, 0, len(fields)*2)
+	for field, value := range fields {
+		args = append(args, field, value)
+	}
+	return args
+}
@@ -0,0 +1,201 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jorgebay/write-behind-cache-worker/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// rowFuncs contains the functions used to build the redis commands of a row.
+type rowFuncs struct {
+	key        config.KeyFunc
+	value      config.ValueFunc
+	fields     config.FieldsFunc
+	expiration config.ExpirationFunc
+	deleted    config.DeleteFunc
+	version    config.VersionFunc
+}
+
+// batch contains the pipeline with the commands of a runOnce execution and the version-guarded writes, whose results
+// are checked after the pipeline is executed.
+type batch struct {
+	redis.Pipeliner
+	guarded []*guardedWrite
+}
+
+func (r *Runner) rowFuncs() (*rowFuncs, error) {
+	var (
+		fns rowFuncs
+		err error
+	)
+
+	if fns.key, err = r.cfg.Redis.KeyFn(r.logger); err != nil {
+		return nil, err
+	}
+
+	if r.cfg.Redis.Mode == config.ModeHash {
+		if fns.fields, err = r.cfg.Redis.FieldsFn(r.logger); err != nil {
+			return nil, err
+		}
+	} else {
+		if fns.value, err = r.cfg.Redis.ValueFn(r.logger); err != nil {
+			return nil, err
+		}
+	}
+
+	if fns.expiration, err = r.cfg.Redis.ExpirationFn(r.logger); err != nil {
+		return nil, err
+	}
+
+	if fns.deleted, err = r.cfg.Redis.DeleteWhen.DeleteFn(r.logger); err != nil {
+		return nil, err
+	}
+
+	fns.version = r.cfg.Redis.VersionFn()
+
+	return &fns, nil
+}
+
+// write queues the redis commands to store the row, applying the null policies when the templates contain
+// null values.
+func (r *Runner) write(ctx context.Context, b *batch, fns *rowFuncs, row map[string]any) error {
+	key, err := fns.key(row)
+	if err != nil {
+		if errors.Is(err, config.ErrSkipRow) {
+			r.logger.Debug("skipping row with null key", zap.Any("row", row))
+			r.metrics.add(metricNullRowSkipped, 1)
+			return nil
+		}
+		if errors.Is(err, config.ErrNullValue) {
+			r.metrics.add(metricNullRowFailed, 1)
+		}
+		return fmt.Errorf("unable to get the key: %w", err)
+	}
+
+	version := ""
+	if fns.version != nil {
+		if version, err = fns.version(row); err != nil {
+			return fmt.Errorf("unable to get the version of key '%s': %w", key, err)
+		}
+	}
+
+	if fns.deleted != nil && fns.deleted(row) {
+		r.logger.Debug("deleting key of deleted row", zap.String("key", key))
+		r.metrics.add(metricKeysDeleted, 1)
+		return r.del(ctx, b, key, version)
+	}
+
+	err = r.writeKey(ctx, b, fns, key, version, row)
+	switch {
+	case errors.Is(err, config.ErrSkipRow):
+		r.logger.Debug("skipping row with null value", zap.String("key", key))
+		r.metrics.add(metricNullRowSkipped, 1)
+		return nil
+	case errors.Is(err, config.ErrDeleteKey):
+		r.logger.Debug("deleting key with null value", zap.String("key", key))
+		r.metrics.add(metricNullKeyDeleted, 1)
+		return r.del(ctx, b, key, version)
+	case errors.Is(err, config.ErrKeyExpired):
+		r.logger.Debug("deleting expired key", zap.String("key", key))
+		r.metrics.add(metricExpiredKeysDeleted, 1)
+		return r.del(ctx, b, key, version)
+	case errors.Is(err, config.ErrNullValue):
+		r.metrics.add(metricNullRowFailed, 1)
+	}
+
+	return err
+}
+
+func (r *Runner) writeKey(
+	ctx context.Context,
+	b *batch,
+	fns *rowFuncs,
+	key string,
+	version string,
+	row map[string]any,
+) error {
+	expiration, err := fns.expiration(row)
+	if err != nil {
+		return fmt.Errorf("unable to get the expiration of key '%s': %w", key, err)
+	}
+
+	if fns.fields != nil {
+		fields, err := fns.fields(row)
+		if err != nil {
+			return fmt.Errorf("unable to get the hash fields of key '%s': %w", key, err)
+		}
+
+		r.logger.Debug("setting hash", zap.String("key", key), zap.Any("fields", fields), zap.Any("expiration", expiration))
+		if version != "" {
+			err = r.guardedWrite(ctx, b, key, version, commandHSet, expiration, hsetArgs(fields)...)
+		} else {
+			err = hset(ctx, b, key, fields, expiration)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to set hash '%s': %w", key, err)
+		}
+		return nil
+	}
+
+	value, err := fns.value(row)
+	if err != nil {
+		return fmt.Errorf("unable to get the value of key '%s': %w", key, err)
+	}
+
+	r.logger.Debug("setting key", zap.String("key", key), zap.Any("value", value), zap.Any("expiration", expiration))
+	if version != "" {
+		err = r.guardedWrite(ctx, b, key, version, commandSet, expiration, value)
+	} else {
+		err = set(ctx, b, key, value, expiration)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to set key '%s': %w", key, err)
+	}
+	return nil
+}
+
+// del queues the command to remove the key, when versioned the version key is kept to prevent older versions from
+// being written.
+func (r *Runner) del(ctx context.Context, b *batch, key string, version string) error {
+	if version != "" {
+		return r.guardedWrite(ctx, b, key, version, r.cfg.Redis.DeleteCommand, config.Expiration{})
+	}
+	if r.cfg.Redis.DeleteCommand == config.DeleteCommandUnlink {
+		return b.Unlink(ctx, key).Err()
+	}
+	return b.Del(ctx, key).Err()
+}
+
+// set queues the SET command of the key, using EX/PX for relative expirations and PXAT for absolute ones.
+func set(ctx context.Context, pipe redis.Pipeliner, key string, value any, expiration config.Expiration) error {
+	if !expiration.At.IsZero() {
+		return pipe.Do(ctx, "set", key, value, "pxat", expiration.At.UnixMilli()).Err()
+	}
+
+	return pipe.Set(ctx, key, value, expiration.TTL).Err()
+}
+
+// hset queues the HSET command of the key followed by the expiration, when defined.
+func hset(
+	ctx context.Context,
+	pipe redis.Pipeliner,
+	key string,
+	fields map[string]any,
+	expiration config.Expiration,
+) error {
+	if err := pipe.HSet(ctx, key, fields).Err(); err != nil {
+		return err
+	}
+
+	switch {
+	case !expiration.At.IsZero():
+		return pipe.PExpireAt(ctx, key, expiration.At).Err()
+	case expiration.TTL > 0:
+		return pipe.PExpire(ctx, key, expiration.TTL).Err()
+	}
+	return nil
+}
@@ -3,15 +3,25 @@ package main
 import (
 	"context"
 	"errors"
+	"expvar"
 	"flag"
 	"fmt"
+	"net/http"
 	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/jorgebay/write-behind-cache-worker/internal/config"
 	"github.com/jorgebay/write-behind-cache-worker/internal/runner"
 	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/microsoft/go-mssqldb"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -19,13 +29,51 @@ import (
 
 var configFlag = flag.String("c", "config.yaml", "help message for flag n")
 
+// Commands of the worker, applied to the jobs named after the command or all of them. When no command is provided,
+// the jobs are run until the process is stopped.
+const (
+	// commandBackfill walks the whole table of the jobs from their backfill checkpoints and exits once completed, it
+	// can run along with the live worker. Exits with status 1 when the backfill of a job fails.
+	commandBackfill = "backfill"
+	// commandVerify compares the keys in redis with the rows of the jobs, exiting with status 1 when they differ and
+	// are not repaired.
+	commandVerify = "verify"
+)
+
 func main() {
 	flag.Parse()
 
+	command := flag.Arg(0)
+	args := flag.Args()[min(1, flag.NArg()):]
+	var verifyOpts runner.VerifyOptions
+	switch command {
+	case "", commandBackfill:
+	case commandVerify:
+		verifyFlags := flag.NewFlagSet(commandVerify, flag.ExitOnError)
+		verifyFlags.BoolVar(&verifyOpts.Repair, "repair", false, "rewrite the missing and stale keys")
+		verifyFlags.BoolVar(&verifyOpts.DeleteExtra, "delete-extra", false, "delete the keys without a row")
+		verifyFlags.StringVar(&verifyOpts.From, "from", "", "verify the rows with a cursor greater than the value")
+		verifyFlags.StringVar(&verifyOpts.To, "to", "", "verify the rows with a cursor up to the value")
+		verifyFlags.Float64Var(&verifyOpts.SampleRate, "sample", 0, "fraction of the rows to verify, between 0 and 1")
+		_ = verifyFlags.Parse(args)
+		args = verifyFlags.Args()
+	default:
+		panic(fmt.Sprintf("unknown command: %s", command))
+	}
+	if verifyOpts.SampleRate < 0 || verifyOpts.SampleRate > 1 {
+		panic(fmt.Sprintf("invalid sample rate: %v", verifyOpts.SampleRate))
+	}
+	jobNames := args
+
 	cfg, cgfFileExists, err := config.Load(*configFlag)
 	if err != nil {
 		panic(fmt.Sprintf("unable to load config: %s", err))
 	}
+	for _, name := range jobNames {
+		if !slices.ContainsFunc(cfg.Jobs, func(job config.JobConfig) bool { return job.Name == name }) {
+			panic(fmt.Sprintf("unknown job: %s", name))
+		}
+	}
 
 	var logger *zap.Logger
 	if cfg.Debug {
@@ -43,6 +91,10 @@ func main() {
 		logger.Info("using config file", zap.String("file", *configFlag))
 	}
 
+	if cfg.MetricsAddress != "" {
+		go serveMetrics(cfg.MetricsAddress, logger)
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
@@ -59,23 +111,159 @@ func main() {
 
 	logger.Info("connected to db")
 
-	opts, err := cfg.Redis.ClientOptions()
-	if err != nil {
-		logger.Fatal("unable to build redis url", zap.Error(err))
+	run := (*runner.Runner).Run
+	var failed, differs atomic.Bool
+	if command == commandBackfill {
+		run = (*runner.Runner).Backfill
+	}
+	verify := func(opts runner.VerifyOptions) func(r *runner.Runner, ctx context.Context) error {
+		return func(r *runner.Runner, ctx context.Context) error {
+			report, err := r.Verify(ctx, opts)
+			if err == nil && report.Differences() > report.Repaired {
+				differs.Store(true)
+			}
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range cfg.Redis.AllTargets() {
+		client, err := target.NewClient()
+		if err != nil {
+			logger.Fatal("unable to build redis client", zap.String("target", target.Name), zap.Error(err))
+		}
+		defer client.Close()
+
+		var opts []runner.Option
+		cursorClient, err := target.NewCursorClient()
+		if err != nil {
+			logger.Fatal("unable to build redis cursor client", zap.String("target", target.Name), zap.Error(err))
+		}
+		var cursorRedisClient redis.UniversalClient = client
+		if cursorClient != nil {
+			defer cursorClient.Close()
+			cursorRedisClient = cursorClient
+			opts = append(opts, runner.WithCursorClient(cursorClient))
+		}
+		opts = append(opts, runner.WithCursorStores(cursorStores(cfg, db, target.Name, cursorRedisClient)...))
+
+		if err := client.Ping(ctx).Err(); err != nil {
+			if len(cfg.Redis.Targets) == 0 {
+				logger.Fatal("unable to connect to redis", zap.Error(err), zap.String("host", target.Host),
+					zap.Strings("addrs", target.Addrs))
+			}
+			// Other targets should not be blocked by an unavailable one
+			logger.Warn("unable to connect to redis target, retrying", zap.String("target", target.Name), zap.Error(err))
+		} else {
+			logger.Info("connected to redis", zap.String("target", target.Name))
+		}
+
+		for _, job := range cfg.Jobs {
+			if len(jobNames) > 0 && !slices.Contains(jobNames, job.Name) {
+				continue
+			}
+			jobRun := run
+			if command == commandVerify {
+				// The keys of the other jobs are not extra keys of the job
+				jobVerifyOpts := verifyOpts
+				jobVerifyOpts.OtherJobs = slices.DeleteFunc(slices.Clone(cfg.Jobs), func(other config.JobConfig) bool {
+					return other.Name == job.Name
+				})
+				jobRun = verify(jobVerifyOpts)
+			}
+			if target.Name != "" {
+				// Each target tracks its own cursor, running as a separate job
+				job.Name += "/" + target.Name
+			}
+			switch command {
+			case commandBackfill:
+				job = job.BackfillJob()
+			case commandVerify:
+				// Verify reads the table in the backfill chunks
+				name := job.Name
+				job = job.BackfillJob()
+				job.Name = name + "/verify"
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// A failed job doesn't stop the others, the worker exits with an error once they complete or are
+				// stopped
+				if err := runJob(ctx, &job, db, client, logger, jobRun, opts...); err != nil {
+					failed.Store(true)
+				}
+			}()
+		}
 	}
 
-	client := redis.NewClient(opts)
-	redisStatus := client.Ping(ctx)
-	if redisStatus.Err() != nil {
-		logger.Fatal("unable to connect to redis", zap.Error(redisStatus.Err()), zap.String("host", cfg.Redis.Host))
+	wg.Wait()
+	switch {
+	case failed.Load():
+		logger.Fatal("one or more jobs ended in error")
+	case differs.Load():
+		logger.Fatal("redis differs from the db")
 	}
+}
 
-	logger.Info("connected to redis")
+func runJob(
+	ctx context.Context,
+	job *config.JobConfig,
+	db *sqlx.DB,
+	client redis.UniversalClient,
+	logger *zap.Logger,
+	run func(r *runner.Runner, ctx context.Context) error,
+	opts ...runner.Option,
+) error {
+	// Wait for the redis target to be available before running the job
+	b := backoff.WithContext(backoff.NewExponentialBackOff(backoff.WithMaxElapsedTime(0)), ctx)
+	if err := backoff.Retry(func() error { return client.Ping(ctx).Err() }, b); err != nil {
+		logger.Info("runner shutting down", zap.String("job", job.Name))
+		return nil
+	}
 
-	r := runner.NewRunner(cfg, db, client, logger)
-	err = r.Run(ctx)
-	logger.Info("runner shutting down")
+	r := runner.NewRunner(job, db, client, logger, opts...)
+	err := run(r, ctx)
+	logger.Info("runner shutting down", zap.String("job", job.Name))
 	if err != nil && !errors.Is(err, context.Canceled) {
-		logger.Warn("runner ended in error", zap.Error(err))
+		logger.Error("runner ended in error", zap.String("job", job.Name), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// cursorStores returns the configured stores of the job cursors for the redis target.
+func cursorStores(
+	cfg *config.Config,
+	db *sqlx.DB,
+	target string,
+	client redis.UniversalClient,
+) []runner.CursorStore {
+	// The dialect was validated when loading the config
+	dialect, _ := cfg.DB.Dialect()
+	stores := make([]runner.CursorStore, 0, len(cfg.CursorStore.Types))
+	for _, storeType := range cfg.CursorStore.Types {
+		switch storeType {
+		case config.CursorStoreRedis:
+			stores = append(stores, runner.NewRedisCursorStore(client))
+		case config.CursorStoreSQL:
+			stores = append(stores, runner.NewSQLCursorStore(db, dialect, cfg.CursorStore.Table, target))
+		case config.CursorStoreFile:
+			stores = append(stores, runner.NewFileCursorStore(cfg.CursorStore.File, target))
+		}
+	}
+	return stores
+}
+
+func serveMetrics(address string, logger *zap.Logger) {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           expvar.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	logger.Info("serving metrics", zap.String("address", address))
+	if err := server.ListenAndServe(); err != nil {
+		logger.Error("unable to serve metrics", zap.Error(err))
 	}
 }